@@ -1,25 +1,47 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"github.com/valivishy/httpfromtcp/internal/server"
+	"github.com/valivishy/httpfromtcp/internal/static"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 const port = 42069
+const shutdownTimeout = 10 * time.Second
 
 func main() {
-	newServer, err := server.Serve(port, server.HandlerFunc)
+	dir := flag.String("dir", "", "serve the files of this directory instead of the demo handler")
+	listing := flag.Bool("listing", false, "list the directories without index.html")
+	showHidden := flag.Bool("hidden", false, "serve and list the files whose name starts with a dot")
+	flag.Parse()
+
+	var handler server.Handler = server.HandlerFunc
+	if *dir != "" {
+		handler = static.FileServerWithOptions(*dir, static.Options{Listing: *listing, ShowHidden: *showHidden})
+	}
+	handler = server.Chain(server.Recover(), server.Logger(nil))(handler)
+
+	newServer, err := server.Serve(port, handler, server.DefaultConfig())
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer newServer.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = newServer.Shutdown(ctx); err != nil {
+		log.Printf("Server forcefully stopped: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
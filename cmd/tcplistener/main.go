@@ -29,12 +29,16 @@ func main() {
 		fmt.Printf("- Version: %s\n", line.HttpVersion)
 
 		fmt.Println("Headers:")
-		for name, value := range fromReader.Headers {
+		for name, value := range fromReader.Headers.All() {
 			fmt.Printf("- %s: %s\n", name, value)
 		}
 
 		fmt.Println("Body:")
-		fmt.Println(string(fromReader.Body))
+		body, err := fromReader.ReadBody(0)
+		if err != nil {
+			fmt.Printf("Error reading body: %v\n", err)
+		}
+		fmt.Println(string(body))
 
 		fmt.Println("Connection closed")
 		safeClose(accept)
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Client sends requests built with request.New, reusing connections through its Transport
+type Client struct {
+	// Transport sends the requests, DefaultTransport when nil
+	Transport *Transport
+	// Timeout bounds a whole exchange, reading the response body included, zero meaning no limit
+	Timeout time.Duration
+}
+
+// Do sends req and returns the response once its headers are read, the caller reads and closes its Body
+func (c *Client) Do(req *request.Request) (*response.Response, error) {
+	transport := c.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+
+	var deadline time.Time
+	if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+
+	return transport.roundTrip(req, deadline)
+}
+
+func (c *Client) Get(url string) (*response.Response, error) {
+	req, err := request.New(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Do(req)
+}
+
+func (c *Client) Post(url, contentType string, body io.Reader) (*response.Response, error) {
+	req, err := request.New(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Headers.Set("Content-Type", contentType)
+
+	return c.Do(req)
+}
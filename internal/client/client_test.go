@@ -0,0 +1,195 @@
+package client
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve starts a server and returns the base URL to reach it
+func serve(t *testing.T, handler server.Handler) string {
+	s, err := server.Serve(0, handler, server.DefaultConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return "http://127.0.0.1:" + strconv.Itoa(s.Addr().(*net.TCPAddr).Port)
+}
+
+// remoteAddr answers with the client address, telling connections apart
+func remoteAddr(w *response.Writer, req *request.Request) *server.HandlerError {
+	body, err := req.ReadBody(0)
+	if err != nil {
+		return &server.HandlerError{StatusCode: 400, Message: err.Error()}
+	}
+
+	return &server.HandlerError{StatusCode: 200, Message: req.RemoteAddr + " " + string(body)}
+}
+
+func readAll(t *testing.T, resp *response.Response) string {
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	return string(body)
+}
+
+func TestClientReusesConnections(t *testing.T) {
+	url := serve(t, remoteAddr)
+	c := &Client{Transport: &Transport{}, Timeout: 5 * time.Second}
+
+	resp, err := c.Get(url + "/")
+	require.NoError(t, err)
+	assert.Equal(t, response.OK, resp.StatusCode)
+	first := readAll(t, resp)
+
+	resp, err = c.Post(url+"/", "text/plain", strings.NewReader("hello"))
+	require.NoError(t, err)
+	second := readAll(t, resp)
+	assert.True(t, strings.HasSuffix(second, " hello"), second)
+
+	// Test: A body read to EOF hands the connection back for the next request
+	assert.Equal(t, strings.Fields(first)[0], strings.Fields(second)[0])
+
+	// Test: Closing the pooled connections makes the next request dial again
+	c.Transport.CloseIdleConnections()
+	resp, err = c.Get(url + "/")
+	require.NoError(t, err)
+	assert.NotEqual(t, strings.Fields(first)[0], strings.Fields(readAll(t, resp))[0])
+}
+
+func TestClientStreamsChunkedBodies(t *testing.T) {
+	url := serve(t, remoteAddr)
+	c := &Client{Transport: &Transport{}, Timeout: 5 * time.Second}
+
+	// Test: A body of unknown length is sent chunked
+	body, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("streamed "))
+		_, _ = writer.Write([]byte("body"))
+		_ = writer.Close()
+	}()
+	resp, err := c.Post(url+"/", "text/plain", body)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(readAll(t, resp), " streamed body"))
+}
+
+func TestClientReadsUntilClose(t *testing.T) {
+	addr := rawServer(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\nX-Sum: 5\r\n\r\n",
+		"HTTP/1.0 200 OK\r\n\r\nuntil the end")
+	c := &Client{Transport: &Transport{}, Timeout: 5 * time.Second}
+
+	resp, err := c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readAll(t, resp))
+	sum, _ := resp.Trailers.Get("X-Sum")
+	assert.Equal(t, "5", sum)
+
+	// Test: A body without length ends with the connection, which is not reused
+	resp, err = c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "until the end", readAll(t, resp))
+	assert.Empty(t, c.Transport.idle[addr])
+}
+
+func TestClientRetriesClosedIdleConnection(t *testing.T) {
+	addr := rawServer(t, "HTTP/1.1 204 No Content\r\n\r\n", "", "HTTP/1.1 204 No Content\r\n\r\n")
+	c := &Client{Transport: &Transport{}, Timeout: 5 * time.Second}
+
+	resp, err := c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	readAll(t, resp)
+
+	// Test: The pooled connection the server closed without answering is replaced transparently
+	resp, err = c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	assert.Equal(t, response.NoContent, resp.StatusCode)
+
+	// Test: A request that is not idempotent is not sent twice
+	addr = rawServer(t, "HTTP/1.1 204 No Content\r\n\r\n", "", "HTTP/1.1 204 No Content\r\n\r\n")
+	resp, err = c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	readAll(t, resp)
+	req, err := request.New("POST", "http://"+addr+"/", nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestClientTimeouts(t *testing.T) {
+	addr := rawServer(t)
+	c := &Client{Transport: &Transport{ResponseHeaderTimeout: 50 * time.Millisecond}}
+
+	_, err := c.Get("http://" + addr + "/")
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	// Test: The client timeout also bounds reading the body
+	addr = rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+	c = &Client{Transport: &Transport{}, Timeout: 100 * time.Millisecond}
+	resp, err := c.Get("http://" + addr + "/")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.ErrorAs(t, err, &netErr)
+
+	_, err = c.Get("https://" + addr + "/")
+	require.ErrorIs(t, err, ErrUnsupportedScheme)
+}
+
+// rawServer answers the requests of each connection in turn with the next of answers, an empty answer
+// closing the connection unanswered and an HTTP/1.0 one closing it once sent. Connections are held open
+// once the answers run out.
+func rawServer(t *testing.T, answers ...string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	next := make(chan string, len(answers))
+	for _, answer := range answers {
+		next <- answer
+	}
+	close(next)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := request.FromReader(reader); err != nil {
+						return
+					}
+					answer, ok := <-next
+					if !ok {
+						return
+					}
+					if answer == "" {
+						_ = conn.Close()
+						return
+					}
+					_, _ = conn.Write([]byte(answer))
+					if strings.HasPrefix(answer, "HTTP/1.0") {
+						_ = conn.Close()
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
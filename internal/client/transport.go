@@ -0,0 +1,257 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrUnsupportedScheme = errors.New("error: only http URLs are supported")
+
+// Transport sends requests over TCP, keeping the connections of finished exchanges to reuse them
+type Transport struct {
+	// DialTimeout bounds connecting to the server
+	DialTimeout time.Duration
+	// ResponseHeaderTimeout bounds waiting for the response headers once the request is sent
+	ResponseHeaderTimeout time.Duration
+	// IdleConnTimeout is how long an unused connection is kept, zero meaning until the server closes it
+	IdleConnTimeout time.Duration
+	// MaxIdleConnsPerHost caps the connections kept for a host, zero meaning DefaultMaxIdleConnsPerHost
+	MaxIdleConnsPerHost int
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+const DefaultMaxIdleConnsPerHost = 2
+
+// DefaultTransport is used by clients without their own
+var DefaultTransport = &Transport{
+	DialTimeout:           30 * time.Second,
+	ResponseHeaderTimeout: time.Minute,
+	IdleConnTimeout:       90 * time.Second,
+}
+
+// persistConn is a connection with the reader holding what the server sent past the last response
+type persistConn struct {
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+	idleAt time.Time
+}
+
+// RoundTrip sends req and reads the response headers, the body being read from the connection as Body is read.
+// The connection goes back to the pool once Body is read to EOF, closing Body earlier closes the connection.
+func (t *Transport) RoundTrip(req *request.Request) (*response.Response, error) {
+	return t.roundTrip(req, time.Time{})
+}
+
+// roundTrip is RoundTrip with a deadline for the whole exchange, the body included
+func (t *Transport) roundTrip(req *request.Request, deadline time.Time) (*response.Response, error) {
+	if req.URL.Form != request.AbsoluteForm || req.URL.Scheme != "http" {
+		return nil, ErrUnsupportedScheme
+	}
+
+	addr := req.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+
+	// A pooled connection may have been closed by the server meanwhile, which is only known once used.
+	// The request is then sent again on a new connection, when it is safe to repeat.
+	for {
+		pc, reused, err := t.getConn(addr, deadline)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.exchange(pc, req, deadline)
+		if err == nil {
+			return resp, nil
+		}
+
+		pc.close()
+		if !reused || !retryable(req, err) {
+			return nil, err
+		}
+	}
+}
+
+func (t *Transport) exchange(pc *persistConn, req *request.Request, deadline time.Time) (*response.Response, error) {
+	if err := pc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := req.Write(pc.conn); err != nil {
+		return nil, err
+	}
+
+	if t.ResponseHeaderTimeout > 0 {
+		headerDeadline := time.Now().Add(t.ResponseHeaderTimeout)
+		if deadline.IsZero() || headerDeadline.Before(deadline) {
+			if err := pc.conn.SetReadDeadline(headerDeadline); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	resp, err := response.Read(pc.reader, req.RequestLine.Method)
+	if err != nil {
+		return nil, err
+	}
+	if err = pc.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	reusable := !resp.Close && !req.Headers.ContainsToken("Connection", "close")
+	resp.Body = &body{body: resp.Body, pc: pc, transport: t, reusable: reusable}
+	return resp, nil
+}
+
+// retryable reports whether req can be sent again after err, which only holds when the server closed
+// the connection without answering, the request is idempotent and there is no body to send again.
+// The server may have acted on the request before closing, see RFC 9110 section 9.2.2.
+func retryable(req *request.Request, err error) bool {
+	switch req.RequestLine.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, net.ErrClosed) {
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) || opErr.Timeout() {
+			return false
+		}
+	}
+
+	contentLength, hasLength := req.Headers.Get("Content-Length")
+	return (!hasLength || contentLength == "0") && !req.Headers.ContainsToken("Transfer-Encoding", "chunked")
+}
+
+// getConn returns an idle connection to addr, or a new one
+func (t *Transport) getConn(addr string, deadline time.Time) (*persistConn, bool, error) {
+	if pc := t.takeIdle(addr); pc != nil {
+		return pc, true, nil
+	}
+
+	dialer := net.Dialer{Timeout: t.DialTimeout, Deadline: deadline}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, false, fmt.Errorf("error: failed to connect to %s: %w", addr, err)
+	}
+
+	return &persistConn{addr: addr, conn: conn, reader: bufio.NewReader(conn)}, false, nil
+}
+
+func (t *Transport) takeIdle(addr string) *persistConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for conns := t.idle[addr]; len(conns) > 0; conns = t.idle[addr] {
+		pc := conns[len(conns)-1]
+		t.idle[addr] = conns[:len(conns)-1]
+		if t.IdleConnTimeout > 0 && time.Since(pc.idleAt) > t.IdleConnTimeout {
+			pc.close()
+			continue
+		}
+		return pc
+	}
+
+	return nil
+}
+
+// putIdle keeps pc for the next request to its host, closing it when the pool is full
+func (t *Transport) putIdle(pc *persistConn) {
+	if err := pc.conn.SetDeadline(time.Time{}); err != nil {
+		pc.close()
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limit := t.MaxIdleConnsPerHost
+	if limit <= 0 {
+		limit = DefaultMaxIdleConnsPerHost
+	}
+	if len(t.idle[pc.addr]) >= limit {
+		pc.close()
+		return
+	}
+
+	if t.idle == nil {
+		t.idle = make(map[string][]*persistConn)
+	}
+	pc.idleAt = time.Now()
+	t.idle[pc.addr] = append(t.idle[pc.addr], pc)
+}
+
+// CloseIdleConnections closes every pooled connection, the ones in use are left alone
+func (t *Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for addr, conns := range t.idle {
+		for _, pc := range conns {
+			pc.close()
+		}
+		delete(t.idle, addr)
+	}
+}
+
+func (pc *persistConn) close() {
+	if err := pc.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Printf("warning: failed to close connection: %v\n", err)
+	}
+}
+
+// body hands the connection back to the transport once the response is read in full
+type body struct {
+	body      io.ReadCloser
+	pc        *persistConn
+	transport *Transport
+	reusable  bool
+	done      bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.done {
+		return 0, io.EOF
+	}
+
+	n, err := b.body.Read(p)
+	if err == io.EOF {
+		b.release(b.reusable)
+	} else if err != nil {
+		b.release(false)
+	}
+
+	return n, err
+}
+
+// Close closes the connection unless the body was read to EOF, what is left of it could not be skipped cheaply
+func (b *body) Close() error {
+	b.release(false)
+
+	return nil
+}
+
+func (b *body) release(reusable bool) {
+	if b.done {
+		return
+	}
+	b.done = true
+
+	if reusable {
+		b.transport.putIdle(b.pc)
+	} else {
+		b.pc.close()
+	}
+}
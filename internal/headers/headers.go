@@ -1,78 +1,330 @@
 package headers
 
 import (
+	"bytes"
 	"errors"
-	"regexp"
+	"fmt"
+	"iter"
+	"slices"
 	"strings"
 )
 
 const (
-	crlf          = "\r\n"
-	invalidHeader = "invalid header"
+	invalidHeader      = "invalid header"
+	invalidHeaderValue = "invalid header value"
+	tokenChars         = "!#$%&'*+-.^_`|~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// Most requests carry fewer fields, parsing them does not need to grow the list
+	initialFieldCount = 16
 )
 
-type Headers map[string]string
+// tokenTable marks the bytes allowed in a token
+var tokenTable = func() (table [256]bool) {
+	for i := 0; i < len(tokenChars); i++ {
+		table[tokenChars[i]] = true
+	}
+
+	return table
+}()
+
+var commonStrings = func() map[string]string {
+	common := make(map[string]string)
+	for _, name := range []string{
+		"Host", "User-Agent", "Accept", "Accept-Language", "Accept-Encoding", "Connection", "Cookie", "Cache-Control",
+		"Content-Type", "Content-Length", "Transfer-Encoding", "Authorization", "Referer", "Origin", "Expect",
+		"If-None-Match", "If-Modified-Since", "Range", "Upgrade", "Pragma", "Te", "Trailer",
+	} {
+		common[name] = name
+		common[strings.ToLower(name)] = strings.ToLower(name)
+	}
+	for _, value := range []string{
+		"*/*", "keep-alive", "close", "chunked", "gzip", "gzip, deflate", "gzip, deflate, br", "identity",
+		"no-cache", "max-age=0", "100-continue", "application/json", "text/plain", "0",
+	} {
+		common[value] = value
+	}
+
+	return common
+}()
+
+var (
+	ErrBareLF                = errors.New("bare LF line ending")
+	ErrWhitespaceBeforeColon = errors.New("whitespace before colon")
+	ErrObsFold               = errors.New("obsolete line folding")
+)
+
+// Headers is an ordered list of fields, keeping the original casing of names and every value of repeated fields.
+// Lookups ignore the case of names. The zero value is an empty list ready to use.
+type Headers struct {
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
+}
+
+func New() *Headers {
+	return &Headers{}
+}
+
+// Get returns the first value of key
+func (h *Headers) Get(key string) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			return f.value, true
+		}
+	}
+
+	return "", false
+}
+
+// Values returns every value of key, in the order they were added
+func (h *Headers) Values(key string) []string {
+	if h == nil {
+		return nil
+	}
 
-func (h Headers) Get(key string) (string, bool) {
-	s, ok := h[strings.ToLower(key)]
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			values = append(values, f.value)
+		}
+	}
+
+	return values
+}
+
+// Add appends a field, after any existing one with the same name
+func (h *Headers) Add(key, value string) {
+	h.fields = append(h.fields, field{name: key, value: value})
+}
+
+// Set replaces every value of key with value, at the position of its first occurrence
+func (h *Headers) Set(key, value string) {
+	for i, f := range h.fields {
+		if strings.EqualFold(f.name, key) {
+			h.fields[i] = field{name: key, value: value}
+			h.fields = append(h.fields[:i+1], deleteName(h.fields[i+1:], key)...)
+			return
+		}
+	}
+
+	h.Add(key, value)
+}
+
+// Del removes every value of key
+func (h *Headers) Del(key string) {
+	h.fields = deleteName(h.fields, key)
+}
 
-	return s, ok
+// Len returns the number of fields, counting every value of repeated ones
+func (h *Headers) Len() int {
+	if h == nil {
+		return 0
+	}
+
+	return len(h.fields)
+}
+
+// All yields every field in order, with the name casing it was added with
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if h == nil {
+			return
+		}
+
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
+// Reset empties the list, keeping its storage for the fields added next
+func (h *Headers) Reset() {
+	clear(h.fields)
+	h.fields = h.fields[:0]
+}
+
+func (h *Headers) Clone() *Headers {
+	if h == nil {
+		return New()
+	}
+
+	return &Headers{fields: slices.Clone(h.fields)}
+}
+
+// ContainsToken reports whether the comma separated values of key contain token, ignoring case
+func (h *Headers) ContainsToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for _, element := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(element), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func deleteName(fields []field, key string) []field {
+	return slices.DeleteFunc(fields, func(f field) bool {
+		return strings.EqualFold(f.name, key)
+	})
+}
+
+// ObsFoldPolicy tells Parse what to do with obsolete line folding, a field line starting with whitespace
+// that continues the previous field value, see RFC 9112 section 5.2
+type ObsFoldPolicy int
+
+const (
+	// RejectObsFold makes folded lines an error, which servers answer with 400
+	RejectObsFold ObsFoldPolicy = iota
+	// ReplaceObsFold joins folded lines to the previous field value with a single space
+	ReplaceObsFold
+)
+
+// Parse parses a single field line from data, rejecting obsolete line folding
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWithPolicy(data, RejectObsFold)
+}
+
+// ParseWithPolicy parses a single field line from data, handling obsolete line folding according to obsFold.
+// It reports done once it meets the empty line ending the field section, without consuming it.
+func (h *Headers) ParseWithPolicy(data []byte, obsFold ObsFoldPolicy) (n int, done bool, err error) {
 	if len(data) < 1 {
 		return 0, false, errors.New("no data provided")
 	}
 
-	potentialTarget := string(data)
-	crlfIndex := strings.Index(potentialTarget, crlf)
-	switch crlfIndex {
-	case -1:
+	lfIndex := bytes.IndexByte(data, '\n')
+	switch {
+	case lfIndex == -1:
 		return 0, false, nil
-	case 0:
+	case lfIndex == 0 || data[lfIndex-1] != '\r':
+		return 0, false, invalid(ErrBareLF)
+	case lfIndex == 1:
 		return 0, true, nil
 	}
 
-	potentialTarget = strings.TrimSpace(potentialTarget[:crlfIndex+2])
+	line := data[:lfIndex-1]
+	n = lfIndex + 1
+	if line[0] == ' ' || line[0] == '\t' {
+		if err = h.unfold(line, obsFold); err != nil {
+			return 0, false, err
+		}
+		return n, false, nil
+	}
 
-	colonIndex := strings.Index(potentialTarget, ":")
+	colonIndex := bytes.IndexByte(line, ':')
 	if colonIndex == -1 {
 		return 0, false, errors.New(invalidHeader)
 	}
 
-	// If the colon is the first symbol, it's invalid
-	headerName := strings.TrimSpace(potentialTarget[:colonIndex])
-	if headerName == "" {
-		return 0, false, errors.New(invalidHeader)
+	// No whitespace is allowed between the name and the colon, RFC 9112 section 5.1
+	name := line[:colonIndex]
+	if len(name) > 0 && isWhitespace(name[len(name)-1]) {
+		return 0, false, invalid(ErrWhitespaceBeforeColon)
 	}
-
-	// If the colon is not right after the header name, it's invalid
-	if len(headerName) != colonIndex {
+	if !isToken(name) {
 		return 0, false, errors.New(invalidHeader)
 	}
 
-	if err = validateHeaderName(headerName); err != nil {
-		return 0, false, err
+	value := trimWhitespace(line[colonIndex+1:])
+	if !validValue(value) {
+		return 0, false, errors.New(invalidHeaderValue)
 	}
 
-	if _, ok := h[strings.ToLower(headerName)]; ok {
-		h[strings.ToLower(headerName)] += ", " + strings.TrimSpace(potentialTarget[colonIndex+1:])
-	} else {
-		h[strings.ToLower(headerName)] = strings.TrimSpace(potentialTarget[colonIndex+1:])
+	if h.fields == nil {
+		h.fields = make([]field, 0, initialFieldCount)
 	}
+	h.Add(intern(name), intern(value))
 
-	return len([]byte(string(data)[:crlfIndex+2])), false, nil
+	return n, false, nil
 }
 
-func validateHeaderName(name string) error {
-	if name == "" {
-		return errors.New(invalidHeader)
+// unfold appends a folded line to the value of the last field
+func (h *Headers) unfold(line []byte, obsFold ObsFoldPolicy) error {
+	if obsFold != ReplaceObsFold || len(h.fields) == 0 {
+		return invalid(ErrObsFold)
 	}
 
-	validHeaderName := regexp.MustCompile(`^[A-Za-z0-9!#$%&'*+\-.\^_` + "`" + `{|}~]+$`)
-	if !validHeaderName.MatchString(name) {
-		return errors.New(invalidHeader)
+	value := trimWhitespace(line)
+	if !validValue(value) {
+		return errors.New(invalidHeaderValue)
+	}
+
+	last := &h.fields[len(h.fields)-1]
+	if last.value == "" {
+		last.value = string(value)
+	} else if len(value) > 0 {
+		last.value += " " + string(value)
 	}
 
 	return nil
 }
+
+func invalid(err error) error {
+	return fmt.Errorf("%s: %w", invalidHeader, err)
+}
+
+// ValidName reports whether name is a token as defined in RFC 9110 section 5.6.2, as field names must be.
+// Methods and transfer codings are tokens too.
+func ValidName(name string) bool {
+	return isToken(name)
+}
+
+// ValidValue reports whether value only holds visible characters, obs-text, spaces and tabs,
+// following the field-value grammar of RFC 9110 section 5.5. It rules out CR, LF and NUL.
+func ValidValue(value string) bool {
+	return validValue(value)
+}
+
+func isToken[T string | []byte](s T) bool {
+	for i := 0; i < len(s); i++ {
+		if !tokenTable[s[i]] {
+			return false
+		}
+	}
+
+	return len(s) > 0
+}
+
+func validValue[T string | []byte](value T) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+func trimWhitespace(b []byte) []byte {
+	for len(b) > 0 && isWhitespace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isWhitespace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+
+	return b
+}
+
+// intern returns the field names and values most requests carry without allocating, in the casing they were sent
+func intern(b []byte) string {
+	if interned, ok := commonStrings[string(b)]; ok {
+		return interned
+	}
+
+	return string(b)
+}
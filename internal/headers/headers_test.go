@@ -7,42 +7,43 @@ import (
 )
 
 func TestParseSingleHeader(t *testing.T) {
-	headers := Headers{}
+	headers := New()
 	data := []byte("Host: localhost:42069\r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
 
 func TestParseSingleHeaderWithExtraWhitespace(t *testing.T) {
-	headers := Headers{}
+	headers := New()
 	data := []byte("Host:     localhost:42069  \r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 29, n)
 	assert.False(t, done)
 }
 
 func TestParseMultipleHeadersWithExisting(t *testing.T) {
-	headers := Headers{"Content-Type": "application/json"}
+	headers := New()
+	headers.Add("Content-Type", "application/json")
 	source := "Host: localhost:42069\r\nAccept: */*\r\n\r\n"
 	data := []byte(source)
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	source = source[n:]
 	data = []byte(source)
 	n, done, _ = headers.Parse(data)
-	assert.Equal(t, "*/*", headers["accept"])
+	assert.Equal(t, []string{"*/*"}, headers.Values("accept"))
 	assert.Equal(t, 13, n)
 	assert.False(t, done)
 
@@ -52,12 +53,12 @@ func TestParseMultipleHeadersWithExisting(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.True(t, done)
 
-	assert.Equal(t, "application/json", headers["Content-Type"])
+	assert.Equal(t, []string{"application/json"}, headers.Values("Content-Type"))
 }
 
 func TestRequestLineParseFailing(t *testing.T) {
 	// Test: Invalid spacing header
-	headers := Headers{}
+	headers := New()
 	data := []byte("       Host : localhost:42069       \r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.Error(t, err)
@@ -67,7 +68,7 @@ func TestRequestLineParseFailing(t *testing.T) {
 
 func TestRequestLineWithEqualsFailing(t *testing.T) {
 	// Test: InvalidHeader
-	headers := Headers{}
+	headers := New()
 	data := []byte("Host=localhost:42069\r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.Error(t, err)
@@ -77,7 +78,7 @@ func TestRequestLineWithEqualsFailing(t *testing.T) {
 
 func TestRequestLineWithInvalidCharacterFailing(t *testing.T) {
 	// Test: InvalidHeader
-	headers := Headers{}
+	headers := New()
 	data := []byte("H©st:localhost:42069\r\n\r\n")
 	n, done, err := headers.Parse(data)
 	require.Error(t, err)
@@ -86,13 +87,14 @@ func TestRequestLineWithInvalidCharacterFailing(t *testing.T) {
 }
 
 func TestParseDuplicateHeadersWithExisting(t *testing.T) {
-	headers := Headers{"content-type": "application/json"}
+	headers := New()
+	headers.Add("content-type", "application/json")
 	source := "Accept: */*\r\nContent-Type: application/xml\r\n\r\n"
 	data := []byte(source)
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "*/*", headers["accept"])
+	assert.Equal(t, []string{"*/*"}, headers.Values("accept"))
 	assert.Equal(t, 13, n)
 	assert.False(t, done)
 
@@ -102,5 +104,195 @@ func TestParseDuplicateHeadersWithExisting(t *testing.T) {
 	assert.Equal(t, 31, n)
 	assert.False(t, done)
 
-	assert.Equal(t, "application/json, application/xml", headers["content-type"])
+	assert.Equal(t, []string{"application/json", "application/xml"}, headers.Values("content-type"))
+}
+
+func TestContainsToken(t *testing.T) {
+	headers := New()
+	headers.Add("connection", "keep-alive, Upgrade")
+	assert.True(t, headers.ContainsToken("Connection", "upgrade"))
+	assert.True(t, headers.ContainsToken("connection", "Keep-Alive"))
+	assert.False(t, headers.ContainsToken("Connection", "close"))
+	assert.False(t, headers.ContainsToken("Upgrade", "websocket"))
+}
+
+func TestDel(t *testing.T) {
+	headers := New()
+	headers.Add("Content-Type", "text/plain")
+	headers.Add("transfer-encoding", "gzip")
+	headers.Add("Transfer-Encoding", "chunked")
+
+	headers.Del("Transfer-Encoding")
+	_, ok := headers.Get("Transfer-Encoding")
+	assert.False(t, ok)
+	assert.Equal(t, 1, headers.Len())
+}
+
+func TestHeadersKeepOrderCasingAndRepeats(t *testing.T) {
+	headers := New()
+	headers.Add("Set-Cookie", "a=1")
+	headers.Add("X-Request-ID", "42")
+	headers.Add("set-cookie", "b=2")
+
+	value, ok := headers.Get("SET-COOKIE")
+	require.True(t, ok)
+	assert.Equal(t, "a=1", value)
+	assert.Equal(t, []string{"a=1", "b=2"}, headers.Values("Set-Cookie"))
+
+	var fields []string
+	for name, value := range headers.All() {
+		fields = append(fields, name+": "+value)
+	}
+	assert.Equal(t, []string{"Set-Cookie: a=1", "X-Request-ID: 42", "set-cookie: b=2"}, fields)
+}
+
+func TestSetReplacesInPlace(t *testing.T) {
+	headers := New()
+	headers.Add("Accept", "text/html")
+	headers.Add("Host", "a")
+	headers.Add("accept", "text/plain")
+	headers.Add("Date", "today")
+
+	headers.Set("Accept", "*/*")
+	assert.Equal(t, []string{"*/*"}, headers.Values("accept"))
+
+	var names []string
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Accept", "Host", "Date"}, names)
+
+	// Test: Set adds missing fields at the end
+	headers.Set("Server", "tcp")
+	assert.Equal(t, 4, headers.Len())
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	headers := New()
+	headers.Add("Host", "a")
+
+	clone := headers.Clone()
+	clone.Set("Host", "b")
+	clone.Add("Accept", "*/*")
+
+	assert.Equal(t, []string{"a"}, headers.Values("Host"))
+	assert.Equal(t, 1, headers.Len())
+	assert.Equal(t, 2, clone.Len())
+}
+
+func TestResetEmptiesTheList(t *testing.T) {
+	headers := New()
+	headers.Add("Host", "a")
+	headers.Reset()
+	assert.Equal(t, 0, headers.Len())
+
+	headers.Add("Accept", "*/*")
+	_, ok := headers.Get("Host")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"*/*"}, headers.Values("Accept"))
+}
+
+func TestNilHeadersReadAsEmpty(t *testing.T) {
+	var headers *Headers
+	_, ok := headers.Get("Host")
+	assert.False(t, ok)
+	assert.Equal(t, 0, headers.Len())
+	assert.False(t, headers.ContainsToken("Connection", "close"))
+	assert.Equal(t, 0, headers.Clone().Len())
+}
+
+func TestParseRejectsInvalidValues(t *testing.T) {
+	for _, line := range []string{
+		"X-Test: a\rb\r\n",
+		"X-Test: a\x00b\r\n",
+		"X-Test: a\x1bb\r\n",
+		"X-Test: a\x7fb\r\n",
+		"X-Test: a\nInjected: b\r\n",
+	} {
+		headers := New()
+		n, done, err := headers.Parse([]byte(line + "\r\n"))
+		require.Error(t, err, line)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+	}
+
+	// Test: Tabs, spaces and obs-text are fine
+	headers := New()
+	_, _, err := headers.Parse([]byte("X-Test: \tcaf\xc3\xa9 au\tlait \r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"caf\xc3\xa9 au\tlait"}, headers.Values("X-Test"))
+}
+
+func TestParseObsFold(t *testing.T) {
+	data := []byte("X-Long: first\r\n  second\r\n\tthird\r\n\r\n")
+
+	// Test: Folding is rejected by default
+	headers := New()
+	n, _, err := headers.Parse(data)
+	require.NoError(t, err)
+	_, _, err = headers.Parse(data[n:])
+	require.Error(t, err)
+
+	// Test: Folding can be replaced with a space
+	headers = New()
+	offset := 0
+	for {
+		n, done, err := headers.ParseWithPolicy(data[offset:], ReplaceObsFold)
+		require.NoError(t, err)
+		if done {
+			break
+		}
+		offset += n
+	}
+	assert.Equal(t, []string{"first second third"}, headers.Values("X-Long"))
+
+	// Test: A folded line needs a field to continue
+	_, _, err = New().ParseWithPolicy([]byte(" orphan\r\n\r\n"), ReplaceObsFold)
+	require.Error(t, err)
+}
+
+func TestValidName(t *testing.T) {
+	for _, token := range []string{"Content-Type", "X-Custom_Header", "!#$%&'*+-.^_`|~", "GET", "a1"} {
+		assert.True(t, ValidName(token), token)
+	}
+
+	// Test: Separators, whitespace, controls and non-ASCII bytes are not token characters
+	for _, notToken := range []string{"", "Content Type", "Name:", "(comment)", "a\"b", "tab\t", "nul\x00", "caf\xc3\xa9", "a,b"} {
+		assert.False(t, ValidName(notToken), notToken)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	data := []byte("Host: localhost:42069\r\n" +
+		"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0\r\n" +
+		"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+		"Accept-Encoding: gzip, deflate, br\r\n" +
+		"Connection: keep-alive\r\n" +
+		"\r\n")
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+
+	for b.Loop() {
+		headers := New()
+		for offset := 0; ; {
+			n, done, err := headers.Parse(data[offset:])
+			if err != nil {
+				b.Fatal(err)
+			}
+			if done {
+				break
+			}
+			offset += n
+		}
+	}
+}
+
+func BenchmarkValidName(b *testing.B) {
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if !ValidName("Content-Type") {
+			b.Fatal("Content-Type is a valid name")
+		}
+	}
 }
@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Hop-by-hop fields only concern a single connection and are never forwarded, see RFC 9110 section 7.6.1
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization", "TE",
+	"Transfer-Encoding", "Upgrade",
+}
+
+// Proxy forwards requests to a single upstream server over plain TCP, a new connection for every request
+type Proxy struct {
+	// Upstream is the host:port of the server requests are forwarded to
+	Upstream string
+	// DialTimeout bounds connecting to the upstream, answered with 504 when exceeded
+	DialTimeout time.Duration
+	// ResponseTimeout bounds waiting for the upstream response headers once the request is sent,
+	// answered with 504 when exceeded
+	ResponseTimeout time.Duration
+}
+
+func New(upstream string) *Proxy {
+	return &Proxy{Upstream: upstream, DialTimeout: 10 * time.Second, ResponseTimeout: time.Minute}
+}
+
+// Handler returns a server.Handler forwarding every request to the upstream
+func (p *Proxy) Handler() server.Handler {
+	return p.serve
+}
+
+func (p *Proxy) serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	upstream, err := net.DialTimeout("tcp", p.Upstream, p.DialTimeout)
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer func() {
+		if err := upstream.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			fmt.Printf("warning: failed to close upstream connection: %v\n", err)
+		}
+	}()
+
+	if err = writeRequest(upstream, req, p.Upstream); err != nil {
+		return upstreamError(err)
+	}
+
+	if err = upstream.SetReadDeadline(server.Deadline(p.ResponseTimeout)); err != nil {
+		return upstreamError(err)
+	}
+	resp, err := response.Read(bufio.NewReader(upstream), req.RequestLine.Method)
+	if err != nil {
+		return upstreamError(err)
+	}
+	// The body is streamed at whatever pace the client reads it
+	if err = upstream.SetReadDeadline(time.Time{}); err != nil {
+		return upstreamError(err)
+	}
+
+	if err = writeResponse(w, resp); err != nil {
+		fmt.Printf("warning: failed to relay upstream response: %v\n", err)
+		return upstreamError(err)
+	}
+
+	return nil
+}
+
+// writeRequest sends req to the upstream, its body streamed with the framing it came with.
+// HTTP/1.0 requests may come without Host, which is then the target authority or the upstream address.
+func writeRequest(upstream net.Conn, req *request.Request, upstreamAddr string) error {
+	outgoing := forwardedHeaders(req)
+	if _, ok := outgoing.Get("Host"); !ok {
+		host := req.URL.Host
+		if host == "" {
+			host = upstreamAddr
+		}
+		outgoing.Set("Host", host)
+	}
+	if req.Headers.ContainsToken("Transfer-Encoding", "chunked") {
+		outgoing.Set("Transfer-Encoding", "chunked")
+	}
+	// One request per connection, the upstream closes it once answered
+	outgoing.Set("Connection", "close")
+
+	// The handlers wrapping the proxy still see the request as the client sent it
+	out := *req
+	out.Headers = outgoing
+	return out.Write(upstream)
+}
+
+// forwardedHeaders returns the request headers stripped of the hop-by-hop ones, with the client recorded
+// in X-Forwarded-For and Forwarded, see RFC 7239
+func forwardedHeaders(req *request.Request) *headers.Headers {
+	outgoing := withoutHopByHop(req.Headers)
+
+	client := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		client = host
+	}
+	if client == "" {
+		return outgoing
+	}
+
+	// Each proxy may have added its own field, they all make up the list
+	previous := outgoing.Values("X-Forwarded-For")
+	outgoing.Set("X-Forwarded-For", strings.Join(append(previous, client), ", "))
+
+	forwarded := "for=" + forwardedNode(client)
+	if host, ok := req.Headers.Get("Host"); ok {
+		if quoted, ok := quote(host); ok {
+			forwarded += ";host=" + quoted
+		}
+	}
+	forwarded += ";proto=http"
+	outgoing.Add("Forwarded", forwarded)
+
+	return outgoing
+}
+
+// forwardedNode quotes IPv6 addresses, whose colons are not allowed in a token
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return "\"[" + ip + "]\""
+	}
+
+	return ip
+}
+
+// quote makes s a quoted-string, escaping only quotes and backslashes, see RFC 9110 section 5.6.4.
+// Control characters cannot be carried by one, false is returned for them.
+func quote(s string) (string, bool) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c < ' ' || c == 0x7f:
+			return "", false
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+
+	return b.String(), true
+}
+
+// withoutHopByHop clones h without the hop-by-hop fields, including those listed in its Connection header
+func withoutHopByHop(h *headers.Headers) *headers.Headers {
+	clone := h.Clone()
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				clone.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		clone.Del(name)
+	}
+
+	return clone
+}
+
+// writeResponse relays the upstream response, its body streamed and re-framed by w
+func writeResponse(w *response.Writer, resp *response.Response) error {
+	if err := w.WriteStatusLineWithReason(resp.StatusCode, resp.Reason); err != nil {
+		return err
+	}
+
+	// A chunked or close-delimited body is left without length, w frames it for the client
+	outgoing := withoutHopByHop(resp.Headers)
+	if resp.Chunked {
+		outgoing.Del("Content-Length")
+	}
+	if err := w.WriteHeaders(outgoing); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+
+	// Trailers are dropped when the client cannot receive them
+	if resp.Trailers.Len() == 0 || !w.Chunked() {
+		return nil
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	return w.WriteTrailers(resp.Trailers)
+}
+
+// upstreamError answers 504 when the upstream did not answer in time and 502 for any other failure
+func upstreamError(err error) *server.HandlerError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &server.HandlerError{StatusCode: int(response.GatewayTimeout), Message: "Gateway Timeout\n"}
+	}
+
+	return &server.HandlerError{StatusCode: int(response.BadGateway), Message: "Bad Gateway\n"}
+}
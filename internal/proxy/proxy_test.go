@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func serve(t *testing.T, handler server.Handler) *server.Server {
+	s, err := server.Serve(0, handler, server.DefaultConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	return s
+}
+
+// roundTrip sends raw to the server and returns everything it answers until it closes the connection
+func roundTrip(t *testing.T, s *server.Server, raw string) string {
+	conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(s.Addr().(*net.TCPAddr).Port))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+
+	return string(responses)
+}
+
+// echo answers with the request line, the headers and the body it received, one per line
+func echo(w *response.Writer, req *request.Request) *server.HandlerError {
+	var b strings.Builder
+	b.WriteString(req.RequestLine.Method + " " + req.RequestLine.RequestTarget + "\n")
+	for name, value := range req.Headers.All() {
+		b.WriteString(name + ": " + value + "\n")
+	}
+	body, err := req.ReadBody(0)
+	if err != nil {
+		return &server.HandlerError{StatusCode: 400, Message: err.Error()}
+	}
+	b.WriteString("body: " + string(body) + "\n")
+	for name, value := range req.Trailers.All() {
+		b.WriteString("trailer " + name + ": " + value + "\n")
+	}
+
+	h := headers.New()
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("X-Upstream", "yes")
+	return &server.HandlerError{StatusCode: 200, Message: b.String(), Headers: h}
+}
+
+func proxyTo(t *testing.T, upstream *server.Server) *server.Server {
+	return serve(t, New(upstream.Addr().String()).Handler())
+}
+
+func TestProxyForwardsRequest(t *testing.T) {
+	front := proxyTo(t, serve(t, echo))
+
+	responses := roundTrip(t, front, "GET http://example.com/items?page=2 HTTP/1.1\r\nHost: example.com\r\n"+
+		"X-Forwarded-For: 10.0.0.1\r\nX-Forwarded-For: 10.0.0.2, 10.0.0.3\r\nConnection: close, X-Hop\r\nX-Hop: drop me\r\nKeep-Alive: timeout=5\r\nX-Custom: kept\r\n\r\n")
+
+	assert.True(t, strings.HasPrefix(responses, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, responses, "X-Upstream: yes\r\n")
+	assert.NotContains(t, responses, "Keep-Alive")
+
+	// Test: The target is sent in origin-form and the hop-by-hop fields are replaced by the proxy's own
+	assert.Contains(t, responses, "GET /items?page=2\n")
+	assert.Contains(t, responses, "X-Custom: kept\n")
+	assert.Contains(t, responses, "Host: example.com\n")
+	assert.NotContains(t, responses, "X-Hop")
+	assert.NotContains(t, responses, "timeout=5\n")
+
+	// Test: The client is recorded after the previous proxies
+	assert.Contains(t, responses, "X-Forwarded-For: 10.0.0.1, 10.0.0.2, 10.0.0.3, 127.0.0.1\n")
+	assert.Equal(t, 1, strings.Count(responses, "X-Forwarded-For"))
+	assert.Contains(t, responses, "Forwarded: for=127.0.0.1;host=\"example.com\";proto=http\n")
+}
+
+func TestForwardedValues(t *testing.T) {
+	// Test: Only quotes and backslashes are escaped, other bytes are kept as they are
+	quoted, ok := quote("a\"b\\c\u00e9")
+	require.True(t, ok)
+	assert.Equal(t, "\"a\\\"b\\\\c\u00e9\"", quoted)
+
+	// Test: Control characters cannot be quoted
+	_, ok = quote("a\x01b")
+	assert.False(t, ok)
+	_, ok = quote("a\tb")
+	assert.False(t, ok)
+
+	assert.Equal(t, "\"[::1]\"", forwardedNode("::1"))
+	assert.Equal(t, "10.0.0.1", forwardedNode("10.0.0.1"))
+}
+
+func TestProxyLeavesRequestUntouched(t *testing.T) {
+	handler := New(serve(t, echo).Addr().String()).Handler()
+	seen := make(chan []string, 1)
+	front := serve(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		handlerError := handler(w, req)
+		connection, _ := req.Headers.Get("Connection")
+		_, forwarded := req.Headers.Get("X-Forwarded-For")
+		seen <- []string{connection, strconv.FormatBool(forwarded)}
+		return handlerError
+	})
+
+	responses := roundTrip(t, front, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: keep-alive, close\r\n\r\n")
+	assert.Contains(t, responses, "Connection: close\n")
+	assert.Equal(t, []string{"keep-alive, close", "false"}, <-seen)
+}
+
+func TestProxyForwardsBodies(t *testing.T) {
+	front := proxyTo(t, serve(t, echo))
+
+	responses := roundTrip(t, front, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\nConnection: close\r\n\r\nhello world")
+	assert.Contains(t, responses, "Content-Length: 11\n")
+	assert.Contains(t, responses, "body: hello world\n")
+
+	// Test: A chunked body keeps its trailers
+	responses = roundTrip(t, front, "POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n"+
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n")
+	assert.Contains(t, responses, "Transfer-Encoding: chunked\n")
+	assert.Contains(t, responses, "body: hello world\n")
+	assert.Contains(t, responses, "trailer X-Checksum: abc\n")
+}
+
+func TestProxyStreamsChunkedResponse(t *testing.T) {
+	stream := server.HandlerOf(t, func(w *response.Writer, req *request.Request) error {
+		if err := w.WriteStatusLine(response.OK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(response.GetChunkedHeaders("X-Content-Length")); err != nil {
+			return err
+		}
+		for _, chunk := range []string{"first ", "second"} {
+			if _, err := w.WriteChunkedBody([]byte(chunk)); err != nil {
+				return err
+			}
+		}
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+
+		trailers := headers.New()
+		trailers.Set("X-Content-Length", "12")
+		return w.WriteTrailers(trailers)
+	})
+	front := proxyTo(t, serve(t, stream))
+
+	responses := roundTrip(t, front, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(responses, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, responses, "Transfer-Encoding: chunked\r\n")
+	assert.Contains(t, responses, "Trailer: X-Content-Length\r\n")
+	assert.True(t, strings.HasSuffix(responses, "\r\n\r\n6\r\nfirst \r\n6\r\nsecond\r\n0\r\nX-Content-Length: 12\r\n\r\n"), responses)
+
+	// Test: An HTTP/1.0 client gets the body delimited by the end of the connection
+	responses = roundTrip(t, front, "GET / HTTP/1.0\r\n\r\n")
+	assert.NotContains(t, responses, "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(responses, "\r\n\r\nfirst second"), responses)
+}
+
+func TestProxyRelaysNotModifiedWithLength(t *testing.T) {
+	notModified := server.HandlerOf(t, func(w *response.Writer, req *request.Request) error {
+		h := headers.New()
+		h.Set("Content-Length", "1234")
+		h.Set("ETag", "\"v1\"")
+		if err := w.WriteStatusLine(response.NotModified); err != nil {
+			return err
+		}
+		return w.WriteHeaders(h)
+	})
+	front := proxyTo(t, serve(t, notModified))
+
+	// Test: The connection survives the 304, the pipelined request behind it is answered
+	responses := roundTrip(t, front, "GET /a HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"v1\"\r\n\r\n"+
+		"GET /b HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"v1\"\r\nConnection: close\r\n\r\n")
+	assert.Equal(t, 2, strings.Count(responses, "HTTP/1.1 304 Not Modified\r\n"), responses)
+	assert.Equal(t, 2, strings.Count(responses, "Content-Length: 1234\r\n"), responses)
+}
+
+func TestProxyUpstreamFailures(t *testing.T) {
+	// Test: An upstream refusing connections is a bad gateway
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	front := serve(t, New(closedAddr).Handler())
+	responses := roundTrip(t, front, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(responses, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: An upstream answering garbage is a bad gateway
+	garbage := rawUpstream(t, "SSH-2.0-OpenSSH_9.6\r\n")
+	front = serve(t, New(garbage).Handler())
+	responses = roundTrip(t, front, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(responses, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: An upstream not answering in time is a gateway timeout
+	silent := rawUpstream(t, "")
+	slowProxy := New(silent)
+	slowProxy.ResponseTimeout = 50 * time.Millisecond
+	front = serve(t, slowProxy.Handler())
+	responses = roundTrip(t, front, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	assert.True(t, strings.HasPrefix(responses, "HTTP/1.1 504 Gateway Timeout\r\n"))
+}
+
+// rawUpstream accepts connections and writes answer to each of them, holding them open until the test ends
+func rawUpstream(t *testing.T, answer string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The connections are closed by the test's cleanup, which must not be registered from the accepting goroutine
+	var mu sync.Mutex
+	var conns []net.Conn
+	closed := false
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if closed {
+				mu.Unlock()
+				_ = conn.Close()
+				return
+			}
+			conns = append(conns, conn)
+			mu.Unlock()
+			_, _ = conn.Write([]byte(answer))
+		}
+	}()
+
+	return listener.Addr().String()
+}
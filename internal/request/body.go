@@ -0,0 +1,140 @@
+package request
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefaultMaxBodyBytes is the body cap of ReadBody when called without one, and of server.DefaultConfig
+const DefaultMaxBodyBytes int64 = 10 << 20
+
+// Reading the body in 8 byte steps would be painfully slow for large uploads
+const bodyBufferSize = 32 * 1024
+
+var bodyBufferPool = sync.Pool{New: func() any { return new([bodyBufferSize]byte) }}
+
+var errBodyClosed = errors.New("error: read on closed body")
+
+// body decodes the request body from the connection as the handler reads it,
+// so nothing beyond the current buffer is ever held in memory
+type body struct {
+	request *Request
+	parser  *Parser
+	// reader is nil when the bytes are fed to the parser by the caller
+	reader io.Reader
+	buffer *[bodyBufferSize]byte
+	closed bool
+	err    error
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	for len(b.request.decoded) == 0 {
+		if b.parser.err != nil {
+			b.err = b.parser.err
+			return 0, b.err
+		}
+		if b.parser.Done() {
+			return 0, io.EOF
+		}
+
+		// The rest of the body is still to be fed, which is no reason to fail for good
+		if b.reader == nil {
+			return 0, b.parser.incompleteError()
+		}
+
+		if err := b.fill(); err != nil {
+			b.err = err
+			b.release()
+			return 0, err
+		}
+		if b.parser.Done() {
+			b.release()
+		}
+	}
+
+	n := copy(p, b.request.decoded)
+	if n == len(b.request.decoded) {
+		b.request.decoded = b.request.decoded[:0]
+	} else {
+		b.request.decoded = b.request.decoded[n:]
+	}
+
+	return n, nil
+}
+
+// fill reads the next bytes of the body and feeds them to the parser
+func (b *body) fill() error {
+	if b.buffer == nil {
+		b.buffer = bodyBufferPool.Get().(*[bodyBufferSize]byte)
+	}
+
+	n, err := b.reader.Read(b.buffer[:])
+	if n > 0 {
+		if _, feedErr := b.parser.Feed(b.buffer[:n]); feedErr != nil {
+			return feedErr
+		}
+	}
+
+	if err == io.EOF && !b.parser.Done() {
+		return b.parser.incompleteError()
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	b.release()
+
+	return nil
+}
+
+// release hands the read buffer back to the pool once the body is no longer read from the connection
+func (b *body) release() {
+	if b.buffer != nil {
+		bodyBufferPool.Put(b.buffer)
+		b.buffer = nil
+	}
+}
+
+// ReadBody reads the whole body into memory, failing when it is larger than maxBytes.
+// A maxBytes of zero or less means DefaultMaxBodyBytes. Repeated calls return the same bytes.
+// For a request of a Parser, it fails with an IncompleteError until the parser is Done.
+func (r *Request) ReadBody(maxBytes int64) ([]byte, error) {
+	if r.bodyBytes != nil {
+		return r.bodyBytes, nil
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBodyBytes
+	}
+
+	// Reading a body still being fed would consume what was decoded so far and lose it with the incomplete error
+	if b, ok := r.Body.(*body); ok && b.reader == nil && b.parser.err == nil && !b.parser.Done() {
+		return nil, b.parser.incompleteError()
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxBytes {
+		return nil, &BodyTooLargeError{Limit: maxBytes}
+	}
+
+	r.bodyBytes = data
+
+	return data, nil
+}
@@ -0,0 +1,236 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"io"
+)
+
+// Errors behind the desync attacks the parser defends against, wrapped by the typed errors below
+var (
+	// ErrBareLF reports a line ended by LF alone instead of CRLF
+	ErrBareLF = headers.ErrBareLF
+	// ErrWhitespaceBeforeColon reports whitespace between a field name and its colon
+	ErrWhitespaceBeforeColon = headers.ErrWhitespaceBeforeColon
+	// ErrObsFold reports a folded header line
+	ErrObsFold = headers.ErrObsFold
+
+	ErrInvalidContentLength              = errors.New("non-numeric Content-Length")
+	ErrConflictingContentLength          = errors.New("conflicting Content-Length values")
+	ErrRepeatedContentLength             = errors.New("repeated Content-Length")
+	ErrContentLengthWithTransferEncoding = errors.New("both Content-Length and Transfer-Encoding are present")
+	ErrChunkedNotLast                    = errors.New("chunked is not the last transfer coding or is applied more than once")
+	ErrUnsupportedTransferCoding         = errors.New("transfer coding other than chunked")
+	ErrWhitespaceAfterChunkSize          = errors.New("whitespace after the chunk size")
+	ErrRepeatedHost                      = errors.New("repeated Host")
+	// ErrTransferEncodingInHTTP10 reports an HTTP/1.0 request framed by Transfer-Encoding, see RFC 9112 section 6.1
+	ErrTransferEncodingInHTTP10 = errors.New("Transfer-Encoding in an HTTP/1.0 request")
+)
+
+// StatusError is implemented by every error returned for a bad request, StatusCode being the status to answer with.
+// Use errors.As to get it, or one of the concrete types below, out of an error chain.
+type StatusError interface {
+	error
+	StatusCode() int
+}
+
+// RequestLineError reports a request line that does not follow method SP request-target SP HTTP-version
+type RequestLineError struct {
+	Line string
+	// Err is the specific reason, when there is one
+	Err error
+}
+
+func (e *RequestLineError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("error: invalid request line: %v: %q", e.Err, e.Line)
+	}
+
+	return fmt.Sprintf("error: invalid request line: %s", e.Line)
+}
+
+func (e *RequestLineError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RequestLineError) StatusCode() int {
+	return 400
+}
+
+// TargetError reports a request-target that is not valid for the request method
+type TargetError struct {
+	Target string
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("error: invalid request target: %s", e.Target)
+}
+
+func (e *TargetError) StatusCode() int {
+	return 400
+}
+
+// MethodError reports a well-formed method the server does not implement
+type MethodError struct {
+	Method string
+}
+
+func (e *MethodError) Error() string {
+	return fmt.Sprintf("error: method not implemented: %s", e.Method)
+}
+
+func (e *MethodError) StatusCode() int {
+	return 501
+}
+
+// VersionError reports a well-formed HTTP version the server does not support
+type VersionError struct {
+	Version string
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("error: unsupported http version: %s", e.Version)
+}
+
+func (e *VersionError) StatusCode() int {
+	return 505
+}
+
+// HeaderError reports a malformed header or trailer field line
+type HeaderError struct {
+	Err error
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("error: invalid header: %v", e.Err)
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HeaderError) StatusCode() int {
+	return 400
+}
+
+// RequestLineTooLongError reports a request line longer than Limit bytes, usually because of its target
+type RequestLineTooLongError struct {
+	Limit int
+}
+
+func (e *RequestLineTooLongError) Error() string {
+	return fmt.Sprintf("error: request line exceeds %d bytes", e.Limit)
+}
+
+func (e *RequestLineTooLongError) StatusCode() int {
+	return 414
+}
+
+// HeaderTooLargeError reports a request line and headers, or trailers, bigger than Limit bytes
+type HeaderTooLargeError struct {
+	Limit int
+}
+
+func (e *HeaderTooLargeError) Error() string {
+	return fmt.Sprintf("error: request header exceeds %d bytes", e.Limit)
+}
+
+func (e *HeaderTooLargeError) StatusCode() int {
+	return 431
+}
+
+// TooManyHeadersError reports more than Limit header or trailer fields
+type TooManyHeadersError struct {
+	Limit int
+}
+
+func (e *TooManyHeadersError) Error() string {
+	return fmt.Sprintf("error: more than %d header fields", e.Limit)
+}
+
+func (e *TooManyHeadersError) StatusCode() int {
+	return 431
+}
+
+// ContentLengthError reports a Content-Length that is not a single non-negative decimal number
+type ContentLengthError struct {
+	Value string
+	Err   error
+}
+
+func (e *ContentLengthError) Error() string {
+	return fmt.Sprintf("error: invalid content length: %v: %s", e.Err, e.Value)
+}
+
+func (e *ContentLengthError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ContentLengthError) StatusCode() int {
+	return 400
+}
+
+// TransferEncodingError reports a Transfer-Encoding the server cannot decode
+type TransferEncodingError struct {
+	Value string
+}
+
+func (e *TransferEncodingError) Error() string {
+	return fmt.Sprintf("error: unsupported transfer encoding: %s", e.Value)
+}
+
+func (e *TransferEncodingError) Unwrap() error {
+	return ErrUnsupportedTransferCoding
+}
+
+func (e *TransferEncodingError) StatusCode() int {
+	return 501
+}
+
+// FramingError reports a body whose length cannot be determined safely, e.g. conflicting headers or a malformed chunk
+type FramingError struct {
+	Err error
+}
+
+func (e *FramingError) Error() string {
+	return fmt.Sprintf("error: invalid body framing: %v", e.Err)
+}
+
+func (e *FramingError) Unwrap() error {
+	return e.Err
+}
+
+func (e *FramingError) StatusCode() int {
+	return 400
+}
+
+// BodyTooLargeError reports a body bigger than Limit bytes
+type BodyTooLargeError struct {
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("error: body exceeds %d bytes", e.Limit)
+}
+
+func (e *BodyTooLargeError) StatusCode() int {
+	return 413
+}
+
+// IncompleteError reports a request cut short while reading Part, it wraps io.ErrUnexpectedEOF
+type IncompleteError struct {
+	Part string
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("error: incomplete %s: %v", e.Part, io.ErrUnexpectedEOF)
+}
+
+func (e *IncompleteError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+func (e *IncompleteError) StatusCode() int {
+	return 400
+}
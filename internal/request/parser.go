@@ -0,0 +1,121 @@
+package request
+
+import (
+	"github.com/valivishy/httpfromtcp/internal/headers"
+)
+
+// Parser parses a request from bytes fed in slices of any size, e.g. by an event loop or from a capture.
+// It buffers incomplete lines itself, so every byte handed to Feed is consumed until the request is complete.
+type Parser struct {
+	options Options
+	// The request and its parts are reused by Reset, so that parsing one allocates little more than its strings
+	request  Request
+	headers  headers.Headers
+	trailers headers.Headers
+	body     body
+	buffer   []byte
+	leftover []byte
+	err      error
+}
+
+func NewParser(options Options) *Parser {
+	p := &Parser{options: options.withDefaults()}
+	p.Reset()
+
+	return p
+}
+
+// Feed parses data, returning how many of its bytes belong to the request. Bytes past the end of the request
+// are not consumed and are kept as Leftover. Errors are final, the parser must be Reset to be used again.
+func (p *Parser) Feed(data []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	if p.Done() {
+		return 0, nil
+	}
+
+	// Bytes are only copied when a line is split across Feed calls
+	input := data
+	if len(p.buffer) > 0 {
+		p.buffer = append(p.buffer, data...)
+		input = p.buffer
+	}
+
+	offset := 0
+	for !p.Done() && offset < len(input) {
+		n, err := p.request.parse(input[offset:])
+		if err != nil {
+			p.err = err
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		offset += n
+	}
+
+	if p.request.Body == nil && p.HeadersComplete() {
+		p.request.Body = &p.body
+	}
+
+	remaining := input[offset:]
+	if p.Done() {
+		p.leftover = append(p.leftover[:0], remaining...)
+		p.buffer = p.buffer[:0]
+		return max(0, len(data)-len(remaining)), nil
+	}
+
+	p.buffer = append(p.buffer[:0], remaining...)
+	return len(data), nil
+}
+
+// HeadersComplete reports whether the request line and headers are parsed, after which Request is available
+func (p *Parser) HeadersComplete() bool {
+	return p.request.requestState != initialized && p.request.requestState != requestStateParsingHeaders
+}
+
+// Done reports whether the whole request, body and trailers included, is parsed
+func (p *Parser) Done() bool {
+	return p.request.requestState == done
+}
+
+// Request returns the request once its headers are parsed, nil before.
+// Its Body yields what was decoded so far and is only complete once Done reports true.
+func (p *Parser) Request() *Request {
+	if !p.HeadersComplete() {
+		return nil
+	}
+
+	return &p.request
+}
+
+// Leftover returns the bytes of the last Feed that came after the end of the request
+func (p *Parser) Leftover() []byte {
+	return p.leftover
+}
+
+// Reset prepares the parser for the next request, the caller feeding it the leftover of the previous one if any.
+// The previous request is overwritten, it must no longer be used.
+func (p *Parser) Reset() {
+	p.body.release()
+	p.headers.Reset()
+	p.trailers.Reset()
+	p.request = Request{requestState: initialized, Headers: &p.headers, Trailers: &p.trailers, decoded: p.request.decoded[:0], options: p.options, parser: p}
+	p.body = body{request: &p.request, parser: p}
+	p.buffer = p.buffer[:0]
+	p.leftover = nil
+	p.err = nil
+}
+
+// incompleteError tells which part of the request was missing when the input ended too early
+func (p *Parser) incompleteError() error {
+	switch {
+	case p.request.requestState == initialized:
+		return &IncompleteError{Part: "request line"}
+	case !p.HeadersComplete():
+		return &IncompleteError{Part: "headers"}
+	}
+
+	return &IncompleteError{Part: "body"}
+}
@@ -0,0 +1,174 @@
+package request
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestParserFeedInSlicesOfAnySize(t *testing.T) {
+	raw := "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n"
+
+	// Test: Every slice size gives the same request
+	for size := 1; size <= len(raw); size++ {
+		parser := NewParser(Options{})
+		for offset := 0; offset < len(raw); offset += size {
+			n, err := parser.Feed([]byte(raw[offset:min(offset+size, len(raw))]))
+			require.NoError(t, err)
+			assert.Equal(t, min(size, len(raw)-offset), n)
+		}
+
+		require.True(t, parser.Done(), "size %d", size)
+		r := parser.Request()
+		assert.Equal(t, "/submit", r.URL.Path)
+		assert.Equal(t, "localhost:42069", header(r.Headers, "host"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(body))
+		assert.Equal(t, "abc", header(r.Trailers, "x-checksum"))
+	}
+}
+
+func TestParserRequestBeforeHeadersComplete(t *testing.T) {
+	parser := NewParser(Options{})
+	_, err := parser.Feed([]byte("GET / HTTP/1.1\r\nHost: local"))
+	require.NoError(t, err)
+	assert.False(t, parser.HeadersComplete())
+	assert.Nil(t, parser.Request())
+
+	_, err = parser.Feed([]byte("host\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, parser.HeadersComplete())
+	assert.True(t, parser.Done())
+	assert.Equal(t, "localhost", header(parser.Request().Headers, "host"))
+}
+
+func TestParserBodyNotFedYet(t *testing.T) {
+	parser := NewParser(Options{})
+	_, err := parser.Feed([]byte("POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello"))
+	require.NoError(t, err)
+	require.True(t, parser.HeadersComplete())
+	require.False(t, parser.Done())
+
+	// Test: The body yields what was fed and asks for more without failing for good
+	r := parser.Request()
+	buffer := make([]byte, 32)
+	n, err := r.Body.Read(buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buffer[:n]))
+	_, err = r.Body.Read(buffer)
+	var incompleteErr *IncompleteError
+	require.ErrorAs(t, err, &incompleteErr)
+
+	_, err = parser.Feed([]byte(" world"))
+	require.NoError(t, err)
+	rest, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, " world", string(rest))
+}
+
+func TestParserReadBodyBeforeDone(t *testing.T) {
+	parser := NewParser(Options{})
+	_, err := parser.Feed([]byte("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nabc"))
+	require.NoError(t, err)
+	require.False(t, parser.Done())
+
+	// Test: Nothing is consumed until the whole body is fed
+	r := parser.Request()
+	_, err = r.ReadBody(0)
+	var incompleteErr *IncompleteError
+	require.ErrorAs(t, err, &incompleteErr)
+
+	_, err = parser.Feed([]byte("de"))
+	require.NoError(t, err)
+	require.True(t, parser.Done())
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "abcde", string(body))
+}
+
+func TestParserPipelinedRequests(t *testing.T) {
+	parser := NewParser(Options{})
+	data := []byte("POST /first HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcGET /second HTTP/1.1\r\n\r\nGET /third HTTP/1.1\r\n\r\n")
+
+	// Test: Bytes past the end of the request are not consumed
+	n, err := parser.Feed(data)
+	require.NoError(t, err)
+	require.True(t, parser.Done())
+	assert.Equal(t, len(data)-len(parser.Leftover()), n)
+	assert.Equal(t, "/first", parser.Request().URL.Path)
+
+	// Test: Feeding a done parser consumes nothing
+	n, err = parser.Feed([]byte("more"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	paths := []string{}
+	for len(parser.Leftover()) > 0 {
+		leftover := parser.Leftover()
+		parser.Reset()
+		_, err = parser.Feed(leftover)
+		require.NoError(t, err)
+		require.True(t, parser.Done())
+		paths = append(paths, parser.Request().URL.Path)
+	}
+	assert.Equal(t, []string{"/second", "/third"}, paths)
+}
+
+func TestParserErrorsAreFinal(t *testing.T) {
+	parser := NewParser(Options{})
+	_, err := parser.Feed([]byte("GET / HTTP/1.1\r\nBad Header: value\r\n\r\n"))
+	var headerErr *HeaderError
+	require.ErrorAs(t, err, &headerErr)
+
+	_, err = parser.Feed([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.ErrorAs(t, err, &headerErr)
+
+	// Test: Reset clears the error
+	parser.Reset()
+	_, err = parser.Feed([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, parser.Done())
+}
+
+// A request as a browser sends it, the shape the fast path is tuned for
+const benchmarkRequest = "GET /api/items?page=2&sort=name HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+	"Accept-Language: en-US,en;q=0.5\r\n" +
+	"Accept-Encoding: gzip, deflate, br\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Cookie: session=7f3a9c0e4b2d; theme=dark\r\n" +
+	"Cache-Control: no-cache\r\n" +
+	"\r\n"
+
+func BenchmarkParser(b *testing.B) {
+	data := []byte(benchmarkRequest)
+	parser := NewParser(Options{})
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+
+	for b.Loop() {
+		parser.Reset()
+		if _, err := parser.Feed(data); err != nil || !parser.Done() {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestParserAllocations(t *testing.T) {
+	data := []byte(benchmarkRequest)
+	parser := NewParser(Options{})
+
+	// Test: Only the request line, its URL and the five field values that are not common ones are allocated
+	allocs := testing.AllocsPerRun(100, func() {
+		parser.Reset()
+		if _, err := parser.Feed(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+	assert.LessOrEqual(t, allocs, 7.0)
+}
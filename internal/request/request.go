@@ -1,6 +1,7 @@
 package request
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/valivishy/httpfromtcp/internal/headers"
@@ -8,6 +9,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type state int
@@ -17,299 +19,689 @@ const (
 	done
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkDataEnd
+	requestStateParsingTrailers
 )
 
 type Request struct {
-	RequestLine  Line
-	Headers      headers.Headers
-	Body         []byte
-	requestState state
+	RequestLine Line
+	URL         *URL
+	Headers     *headers.Headers
+	Body        io.ReadCloser
+	Trailers    *headers.Headers
+	// RemoteAddr is the host:port of the client, set by the server that read the request
+	RemoteAddr    string
+	requestState  state
+	bodyRemaining int64
+	hasBody       bool
+	decoded       []byte
+	bodyBytes     []byte
+	parser        *Parser
+	pathValues    map[string]string
+	options       Options
+	headerBytes   int
+	chunkedBytes  int64
+}
+
+// Options tunes how lenient the parser is
+type Options struct {
+	// ObsFold decides whether folded header lines are rejected, the default, or joined with a space
+	ObsFold headers.ObsFoldPolicy
+	// Strict rejects every ambiguity a front-end and this parser could disagree on, as needed behind a proxy:
+	// repeated Content-Length or Host fields, transfer codings other than a single chunked or in an HTTP/1.0
+	// request, folded lines, and whitespace around the request line or after a chunk size
+	Strict bool
+
+	// The limits below keep a client from making the parser buffer or decode without end.
+	// Zero means the matching default, a negative value means no limit.
+
+	// MaxRequestLineBytes caps the request line, answered with 414
+	MaxRequestLineBytes int
+	// MaxHeaderBytes caps the request line and header section together, and the trailer section, answered with 431
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header fields, and of trailer fields, answered with 431
+	MaxHeaderCount int
+	// MaxBodyBytes caps the decoded body, answered with 413. Unlike the other limits zero means none, the body
+	// being streamed to the handler, which caps it through ReadBody or server.Config.
+	MaxBodyBytes int64
+}
+
+const (
+	DefaultMaxRequestLineBytes = 8 << 10
+	DefaultMaxHeaderBytes      = 1 << 20
+	DefaultMaxHeaderCount      = 100
+)
+
+// withDefaults replaces the zero limits with their defaults
+func (o Options) withDefaults() Options {
+	if o.MaxRequestLineBytes == 0 {
+		o.MaxRequestLineBytes = DefaultMaxRequestLineBytes
+	}
+	if o.MaxHeaderBytes == 0 {
+		o.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if o.MaxHeaderCount == 0 {
+		o.MaxHeaderCount = DefaultMaxHeaderCount
+	}
+
+	return o
 }
 
 type Line struct {
 	HttpVersion   string
 	RequestTarget string
 	Method        string
+	ProtoMajor    int
+	ProtoMinor    int
+}
+
+// ProtoAtLeast reports whether the request version is at least major.minor
+func (l Line) ProtoAtLeast(major, minor int) bool {
+	return l.ProtoMajor > major || (l.ProtoMajor == major && l.ProtoMinor >= minor)
 }
 
-const bufferSize = 8
+// Headers are read in small steps, reading more would only pull body bytes in early
+const headerReadSize = 1 << 10
+
+// Read buffers are shared between requests, a busy server would otherwise allocate them for every one
+var headerBufferPool = sync.Pool{New: func() any { return new([headerReadSize]byte) }}
+
 const crlf = "\r\n"
 
-var httpMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace}
+// A chunk size is at most 16 hex digits, anything longer would overflow an int64
+const maxChunkSizeDigits = 16
+
+// Chunk extensions are ignored, there is no reason to accept long ones
+const maxChunkLineBytes = 4 << 10
+
+// A request line without CRLF is only quoted this far in errors
+const maxErrorLineBytes = 256
+
+// Methods are the methods the parser accepts, those registered by RFC 9110 and PATCH
+var Methods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace}
 
 func FromReader(reader io.Reader) (*Request, error) {
-	buffer := make([]byte, bufferSize, bufferSize*2)
-	readBytes := 0
-	request := Request{requestState: initialized, Headers: make(headers.Headers)}
-
-	for request.requestState != done {
-		buffer = resizeBuffer(readBytes, buffer)
-
-		var n int
-		var err error
-		if request.requestState == requestStateParsingBody {
-			buffer, readBytes, err = readBody(reader, buffer, readBytes, err)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			n, err = reader.Read(buffer[readBytes : readBytes+bufferSize])
-			if err != nil && err == io.EOF {
-				request.requestState = done
-				break
+	return FromReaderWithOptions(reader, Options{})
+}
+
+// FromReaderWithOptions parses the request line and headers from reader. The body is decoded from reader
+// as Body is read, and parsing stops at the end of the request, whatever follows being kept as Leftover.
+func FromReaderWithOptions(reader io.Reader, options Options) (*Request, error) {
+	parser := NewParser(options)
+	pooled := headerBufferPool.Get().(*[headerReadSize]byte)
+	defer headerBufferPool.Put(pooled)
+	buffer := pooled[:]
+
+	for !parser.HeadersComplete() {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			// An error past the headers belongs to the body, it is returned when reading it
+			if _, feedErr := parser.Feed(buffer[:n]); feedErr != nil && !parser.HeadersComplete() {
+				return nil, feedErr
 			}
-			readBytes += n
 		}
 
-		parsed, err := request.parse(buffer)
-		if err != nil {
-			return nil, err
+		if err == io.EOF && !parser.HeadersComplete() {
+			return nil, parser.incompleteError()
 		}
-		if parsed == 0 {
-			continue
+		if err != nil && err != io.EOF {
+			return nil, err
 		}
-
-		readBytes, buffer = rebuildBuffer(buffer, parsed, readBytes)
 	}
 
-	return finalCheck(request)
+	request := parser.Request()
+	parser.body.reader = reader
+	request.Body = &parser.body
+
+	return request, nil
 }
 
-func finalCheck(request Request) (*Request, error) {
-	if request.requestState == done && request.RequestLine == (Line{}) {
-		return nil, errors.New("error: request line not found")
+// Leftover returns the bytes read past the end of the request, e.g. the start of a pipelined request.
+// It is only meaningful once the body has been read to EOF.
+func (r *Request) Leftover() []byte {
+	if r.parser == nil || len(r.parser.Leftover()) == 0 {
+		return nil
 	}
 
-	return &request, nil
+	leftover := make([]byte, len(r.parser.Leftover()))
+	copy(leftover, r.parser.Leftover())
+
+	return leftover
 }
 
-func readBody(reader io.Reader, buffer []byte, readBytes int, err error) ([]byte, int, error) {
-	for {
-		buffer = resizeBuffer(readBytes, buffer)
+// HasBody reports whether the framing of the request announces a body, a Content-Length of zero announcing none
+func (r *Request) HasBody() bool {
+	return r.hasBody
+}
 
-		if isEmpty(buffer[readBytes:]) {
-			break
-		}
+// PathValue returns the value captured for name by the route that matched the request
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
 
-		n, err := reader.Read(buffer[readBytes : readBytes+bufferSize])
-		if err != nil && err == io.EOF {
-			break
-		}
-		readBytes += n
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
 	}
-	buffer = buffer[:readBytes]
 
-	return buffer, readBytes, err
+	r.pathValues[name] = value
 }
 
-func rebuildBuffer(buffer []byte, parsed int, readBytes int) (int, []byte) {
-	var temp []byte
-	if len(buffer) > parsed {
-		temp = make([]byte, len(buffer)-parsed)
-		readBytes -= parsed
-	} else {
-		temp = make([]byte, len(buffer))
-		readBytes = parsed
+func (r *Request) parse(data []byte) (int, error) {
+	switch r.requestState {
+	case initialized:
+		return r.parseLine(data)
+	case requestStateParsingHeaders:
+		return r.parseHeaders(data)
+	case requestStateParsingBody:
+		return r.parseBody(data)
+	case requestStateParsingChunkSize:
+		return r.parseChunkSize(data)
+	case requestStateParsingChunkData:
+		return r.parseChunkData(data)
+	case requestStateParsingChunkDataEnd:
+		return r.parseChunkDataEnd(data)
+	case requestStateParsingTrailers:
+		return r.parseTrailers(data)
+	case done:
+		return -1, errors.New("error: trying to read data in a done state")
 	}
-	copy(temp, buffer[parsed:])
-	buffer = temp
 
-	return readBytes, buffer
+	return -1, errors.New("error: unknown state")
 }
 
-func resizeBuffer(readBytes int, buffer []byte) []byte {
-	if readBytes >= cap(buffer)/2 {
-		temp := make([]byte, (cap(buffer)+bufferSize)*2)
-		copy(temp, buffer[:readBytes])
-		buffer = temp
+func (r *Request) parseBody(data []byte) (int, error) {
+	n := r.appendBody(data)
+	if r.bodyRemaining == 0 {
+		r.requestState = done
 	}
-	return buffer
+
+	return n, nil
 }
 
-func (r *Request) parse(data []byte) (int, error) {
-	if r.requestState == initialized {
-		return r.parseLine(data)
+// appendBody hands as much of data to the body reader as the current length allows
+func (r *Request) appendBody(data []byte) int {
+	n := int(min(int64(len(data)), r.bodyRemaining))
+	r.decoded = append(r.decoded, data[:n]...)
+	r.bodyRemaining -= int64(n)
+
+	return n
+}
+
+func (r *Request) parseChunkSize(data []byte) (int, error) {
+	crlfIndex, err := lineEnd(data)
+	if err != nil {
+		return -1, &FramingError{Err: err}
+	}
+	if crlfIndex == -1 {
+		if len(data) > maxChunkLineBytes {
+			return -1, &FramingError{Err: errors.New("chunk size line too long")}
+		}
+		return 0, nil
 	}
 
-	if r.requestState == requestStateParsingHeaders {
-		return r.parseHeaders(data)
+	size, err := parseChunkSizeLine(string(data[:crlfIndex]), r.options.Strict)
+	if err != nil {
+		return -1, err
 	}
 
-	if r.requestState == requestStateParsingBody {
-		return r.parseBody(data)
+	if limit := r.options.MaxBodyBytes; limit > 0 && size > limit-r.chunkedBytes {
+		return -1, &BodyTooLargeError{Limit: limit}
 	}
+	r.chunkedBytes += size
 
-	if r.requestState == done {
-		return -1, errors.New("error: trying to read data in a done state")
+	if size == 0 {
+		r.requestState = requestStateParsingTrailers
+		r.headerBytes = 0
+	} else {
+		r.bodyRemaining = size
+		r.requestState = requestStateParsingChunkData
 	}
 
-	return -1, errors.New("error: unknown state")
+	return crlfIndex + len(crlf), nil
 }
 
-func (r *Request) parseBody(data []byte) (int, error) {
-	contentLengthString, ok := r.Headers.Get("Content-Length")
-	if !ok {
-		r.requestState = done
+func (r *Request) parseChunkData(data []byte) (int, error) {
+	n := r.appendBody(data)
+	if r.bodyRemaining == 0 {
+		r.requestState = requestStateParsingChunkDataEnd
+	}
+
+	return n, nil
+}
+
+func (r *Request) parseChunkDataEnd(data []byte) (int, error) {
+	if len(data) < len(crlf) {
 		return 0, nil
 	}
 
-	contentLength, err := strconv.Atoi(contentLengthString)
+	if string(data[:len(crlf)]) != crlf {
+		return -1, &FramingError{Err: errors.New("chunk data not followed by CRLF")}
+	}
+
+	r.requestState = requestStateParsingChunkSize
+	return len(crlf), nil
+}
+
+func (r *Request) parseTrailers(data []byte) (int, error) {
+	n, d, err := r.Trailers.ParseWithPolicy(data, r.obsFold())
 	if err != nil {
+		return -1, &HeaderError{Err: err}
+	}
+
+	if err = r.checkFieldLimits(r.Trailers, data, n, d); err != nil {
 		return -1, err
 	}
 
-	// Remove the CRLF
-	data = data[2:]
+	if d {
+		r.requestState = done
+		return len(crlf), nil
+	}
+
+	return n, nil
+}
 
-	temp := make([]byte, len(r.Body)+len(data))
-	copy(temp, r.Body)
-	copy(temp[len(r.Body):], data)
-	r.Body = temp
+// checkFieldLimits counts the bytes of a header or trailer section as its lines are parsed, n being the size of
+// the line just parsed or zero when data does not hold a whole line yet
+func (r *Request) checkFieldLimits(fields *headers.Headers, data []byte, n int, done bool) error {
+	switch {
+	case done:
+		r.headerBytes += len(crlf)
+	case n == 0:
+		// Nothing is consumed until the line is complete, which is no reason to buffer it forever
+		if limit := r.options.MaxHeaderBytes; limit > 0 && r.headerBytes+len(data) > limit {
+			return &HeaderTooLargeError{Limit: limit}
+		}
+		return nil
+	default:
+		r.headerBytes += n
+	}
 
-	if len(r.Body) > contentLength {
-		return -1, errors.New("error: body length exceeds content length")
+	if limit := r.options.MaxHeaderBytes; limit > 0 && r.headerBytes > limit {
+		return &HeaderTooLargeError{Limit: limit}
 	}
 
-	if len(r.Body) == contentLength {
-		r.requestState = done
+	if limit := r.options.MaxHeaderCount; limit > 0 && fields.Len() > limit {
+		return &TooManyHeadersError{Limit: limit}
+	}
+
+	return nil
+}
+
+// parseChunkSizeLine parses chunk-size [ chunk-ext ] as defined in RFC 9112 section 7.1,
+// extensions are validated and then ignored. Strict mode only allows whitespace before an extension.
+func parseChunkSizeLine(line string, strict bool) (int64, error) {
+	sizePart, extensions, hasExtensions := strings.Cut(line, ";")
+	trimmed := strings.TrimRight(sizePart, " \t")
+	if strict && !hasExtensions && trimmed != sizePart {
+		return -1, &FramingError{Err: ErrWhitespaceAfterChunkSize}
+	}
+	sizePart = trimmed
+
+	if len(sizePart) < 1 || len(sizePart) > maxChunkSizeDigits || !isHexNumber(sizePart) {
+		return -1, invalidChunkSize(line)
+	}
+
+	size, err := strconv.ParseInt(sizePart, 16, 64)
+	if err != nil || size < 0 {
+		return -1, invalidChunkSize(line)
+	}
+
+	if extensions != "" {
+		if err = validateChunkExtensions(extensions); err != nil {
+			return -1, err
+		}
+	}
+
+	return size, nil
+}
+
+func validateChunkExtensions(extensions string) error {
+	for _, extension := range splitOutsideQuotes(extensions, ';') {
+		name, value, hasValue := strings.Cut(extension, "=")
+		name = strings.Trim(name, " \t")
+		if !headers.ValidName(name) {
+			return &FramingError{Err: fmt.Errorf("invalid chunk extension: %s", extension)}
+		}
+
+		if !hasValue {
+			continue
+		}
+
+		value = strings.Trim(value, " \t")
+		if !headers.ValidName(value) && !isQuotedString(value) {
+			return &FramingError{Err: fmt.Errorf("invalid chunk extension: %s", extension)}
+		}
+	}
+
+	return nil
+}
+
+// splitOutsideQuotes splits s around sep, ignoring separators inside quoted strings
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
 	}
 
-	return len(data), nil
+	return append(parts, s[start:])
+}
+
+func invalidChunkSize(line string) error {
+	return &FramingError{Err: fmt.Errorf("invalid chunk size: %s", line)}
 }
 
 func (r *Request) parseHeaders(data []byte) (int, error) {
-	n, d, err := r.Headers.Parse(data)
+	n, d, err := r.Headers.ParseWithPolicy(data, r.obsFold())
 	if err != nil {
+		return -1, &HeaderError{Err: err}
+	}
+
+	if err = r.checkFieldLimits(r.Headers, data, n, d); err != nil {
 		return -1, err
 	}
 
 	if d {
-		if cl, ok := r.Headers.Get("Content-Length"); ok && r.RequestLine.Method != http.MethodGet {
-			if _, err := strconv.Atoi(cl); err == nil {
-				r.requestState = requestStateParsingBody
-				return n, nil
-			}
+		if err = r.checkHeaders(); err != nil {
+			return -1, err
 		}
-		r.requestState = done
+		if err = r.prepareBody(); err != nil {
+			return -1, err
+		}
+		return len(crlf), nil
 	}
 
 	return n, nil
 }
 
-func (r *Request) parseLine(data []byte) (int, error) {
-	line, bytesRead, err := parseRequestLine(string(data))
-	if err != nil {
-		return -1, err
+// prepareBody picks the body framing once all headers are known, following RFC 9112 section 6.3
+func (r *Request) prepareBody() error {
+	transferEncodings := r.Headers.Values("Transfer-Encoding")
+	contentLengths := r.Headers.Values("Content-Length")
+	hasTransferEncoding, hasContentLength := len(transferEncodings) > 0, len(contentLengths) > 0
+
+	if hasTransferEncoding && hasContentLength {
+		return &FramingError{Err: ErrContentLengthWithTransferEncoding}
 	}
-	if bytesRead == 0 {
-		return 0, nil
+
+	if hasTransferEncoding {
+		// HTTP/1.0 knows no Transfer-Encoding, the server closes the connection after answering such a request
+		if r.options.Strict && !r.RequestLine.ProtoAtLeast(1, 1) {
+			return &FramingError{Err: ErrTransferEncodingInHTTP10}
+		}
+		if err := checkTransferEncoding(transferEncodings, r.options.Strict); err != nil {
+			return err
+		}
+		r.requestState = requestStateParsingChunkSize
+		r.hasBody = true
+		return nil
 	}
-	r.RequestLine = line
-	r.requestState = requestStateParsingHeaders
-	return bytesRead, nil
+
+	if hasContentLength {
+		length, err := parseContentLength(contentLengths, r.options.Strict)
+		if err != nil {
+			return err
+		}
+		if limit := r.options.MaxBodyBytes; limit > 0 && length > limit {
+			return &BodyTooLargeError{Limit: limit}
+		}
+		if length > 0 {
+			r.bodyRemaining = length
+			r.requestState = requestStateParsingBody
+			r.hasBody = true
+			return nil
+		}
+	}
+
+	r.requestState = done
+	return nil
+}
+
+// checkTransferEncoding requires chunked to be applied once and last, RFC 9112 section 6.3.
+// Strict mode also refuses any other coding, which the body reader would pass through undecoded.
+func checkTransferEncoding(values []string, strict bool) error {
+	transferEncoding := strings.Join(values, ", ")
+
+	var codings []string
+	for _, coding := range strings.Split(transferEncoding, ",") {
+		if coding = strings.Trim(coding, " \t"); coding != "" {
+			codings = append(codings, coding)
+		}
+	}
+
+	if len(codings) == 0 {
+		return &FramingError{Err: ErrChunkedNotLast}
+	}
+
+	for i, coding := range codings {
+		isChunked := strings.EqualFold(coding, "chunked")
+		switch {
+		case isChunked != (i == len(codings)-1):
+			return &FramingError{Err: ErrChunkedNotLast}
+		case !isChunked && strict:
+			return &TransferEncodingError{Value: transferEncoding}
+		}
+	}
+
+	return nil
+}
+
+// parseContentLength accepts repeated Content-Length values as long as they all agree, RFC 9112 section 6.3.
+// Strict mode wants a single value.
+func parseContentLength(values []string, strict bool) (int64, error) {
+	contentLength := strings.Join(values, ", ")
+	elements := strings.Split(contentLength, ",")
+
+	length := int64(-1)
+	for _, element := range elements {
+		// ParseInt alone would let signs through, e.g. +5
+		element = strings.Trim(element, " \t")
+		parsed, err := strconv.ParseInt(element, 10, 64)
+		if err != nil || !isDecimalNumber(element) {
+			return -1, &ContentLengthError{Value: contentLength, Err: ErrInvalidContentLength}
+		}
+
+		if length != -1 && parsed != length {
+			return -1, &ContentLengthError{Value: contentLength, Err: ErrConflictingContentLength}
+		}
+		length = parsed
+	}
+
+	if strict && len(elements) > 1 {
+		return -1, &ContentLengthError{Value: contentLength, Err: ErrRepeatedContentLength}
+	}
+
+	return length, nil
 }
 
-func parseRequestLine(line string) (Line, int, error) {
-	if !strings.Contains(line, crlf) {
-		return Line{}, 0, nil
+// obsFold never lets folded lines through in strict mode
+func (r *Request) obsFold() headers.ObsFoldPolicy {
+	if r.options.Strict {
+		return headers.RejectObsFold
 	}
 
-	split := strings.Split(line, crlf)
-	if len(split) < 1 {
-		return Line{}, -1, invalidRequestLine(line)
+	return r.options.ObsFold
+}
+
+// checkHeaders applies the strict mode rules that do not concern the body
+func (r *Request) checkHeaders() error {
+	if r.options.Strict && len(r.Headers.Values("Host")) > 1 {
+		return &HeaderError{Err: ErrRepeatedHost}
+	}
+
+	return nil
+}
+
+func isDecimalNumber(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+func isHexNumber(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isHex(s[i]) {
+			return false
+		}
+	}
+
+	return s != ""
+}
+
+// lineEnd returns the index of the first CRLF in data, or -1 when there is none yet.
+// A LF without CR is an error: lenient parsers take it as a line end and could be made to disagree with us.
+func lineEnd(data []byte) (int, error) {
+	crlfIndex := bytes.Index(data, []byte(crlf))
+	searched := data
+	if crlfIndex != -1 {
+		searched = data[:crlfIndex]
+	}
+
+	if bytes.IndexByte(searched, '\n') != -1 {
+		return -1, ErrBareLF
+	}
+
+	return crlfIndex, nil
+}
+
+func (r *Request) parseLine(data []byte) (int, error) {
+	crlfIndex, err := lineEnd(data)
+	if err != nil {
+		return -1, &RequestLineError{Line: string(data[:min(len(data), maxErrorLineBytes)]), Err: err}
 	}
 
-	requestLine := strings.TrimSpace(split[0])
-	if requestLine == "" {
-		return Line{}, -1, invalidRequestLine(line)
+	// Without a CRLF yet, limit+1 bytes may still be a line of limit bytes followed by CR
+	limit := r.options.MaxRequestLineBytes
+	if limit > 0 && ((crlfIndex == -1 && len(data) > limit+1) || crlfIndex > limit) {
+		return -1, &RequestLineTooLongError{Limit: limit}
+	}
+	if crlfIndex == -1 {
+		return 0, nil
 	}
 
-	lineComponents := strings.Split(requestLine, " ")
-	if len(lineComponents) != 3 {
-		return Line{}, -1, invalidRequestLine(line)
+	line, err := parseRequestLine(string(data[:crlfIndex]), r.options.Strict)
+	if err != nil {
+		return -1, err
 	}
 
-	method, err := getMethod(lineComponents[0])
+	bytesRead := crlfIndex + len(crlf)
+	r.headerBytes = bytesRead
+	target, err := ParseTarget(line.Method, line.RequestTarget)
 	if err != nil {
-		return Line{}, -1, err
+		return -1, err
+	}
+
+	r.RequestLine = line
+	r.URL = target
+	r.requestState = requestStateParsingHeaders
+	return bytesRead, nil
+}
+
+// parseRequestLine parses method SP request-target SP HTTP-version, line being stripped of its CRLF
+func parseRequestLine(line string, strict bool) (Line, error) {
+	requestLine := strings.TrimSpace(line)
+	if requestLine == "" || (strict && requestLine != line) {
+		return Line{}, invalidRequestLine(line)
 	}
 
-	target, err := getTarget(lineComponents[1])
+	methodPart, rest, _ := strings.Cut(requestLine, " ")
+	target, versionPart, found := strings.Cut(rest, " ")
+	if !found || strings.IndexByte(versionPart, ' ') != -1 {
+		return Line{}, invalidRequestLine(line)
+	}
+
+	method, err := getMethod(methodPart)
 	if err != nil {
-		return Line{}, -1, err
+		return Line{}, err
 	}
 
-	httpVersion, err := getHttpVersion(lineComponents[2])
+	httpVersion, major, minor, err := getHttpVersion(versionPart)
 	if err != nil {
-		return Line{}, -1, err
+		return Line{}, err
 	}
 
 	return Line{
-			HttpVersion:   httpVersion,
-			RequestTarget: target,
-			Method:        method,
-		},
-		len([]byte(split[0])) + len(crlf),
-		nil
+		HttpVersion:   httpVersion,
+		RequestTarget: target,
+		Method:        method,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+	}, nil
 }
 
 func invalidRequestLine(line string) error {
-	return fmt.Errorf("invalid request line: %s", line)
+	return &RequestLineError{Line: line}
 }
 
 func getMethod(component string) (string, error) {
-	if strings.ToUpper(component) != component {
+	if !headers.ValidName(component) {
 		return "", invalidRequestLine(component)
 	}
 
-	for _, method := range httpMethods {
+	for _, method := range Methods {
 		if method == component {
 			return method, nil
 		}
 	}
 
-	return "", invalidRequestLine(component)
+	return "", &MethodError{Method: component}
 }
 
-func getTarget(component string) (string, error) {
-	if len(component) < 1 {
-		return "", invalidRequestLine(component)
-	}
-
-	if component == "/" {
-		return component, nil
+// getHttpVersion accepts HTTP/1.0 and HTTP/1.1, any other well-formed HTTP/d.d version is unsupported
+func getHttpVersion(component string) (string, int, int, error) {
+	switch component {
+	case "HTTP/1.1":
+		return "1.1", 1, 1, nil
+	case "HTTP/1.0":
+		return "1.0", 1, 0, nil
 	}
 
-	paths := strings.Split(component[1:], "/")
-	if len(paths) < 1 {
-		return "", invalidRequestLine(component)
+	if len(component) != len("HTTP/d.d") || !strings.HasPrefix(component, "HTTP/") ||
+		!isDigit(component[5]) || component[6] != '.' || !isDigit(component[7]) {
+		return "", 0, 0, invalidRequestLine(component)
 	}
 
-	for _, path := range paths {
-		if len(path) < 1 {
-			return "", invalidRequestLine(component)
-		}
-	}
+	return "", 0, 0, &VersionError{Version: component}
+}
 
-	return component, nil
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
 
-func getHttpVersion(component string) (string, error) {
-	if component != "HTTP/1.1" {
-		return "", invalidRequestLine(component)
+func isQuotedString(s string) bool {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return false
 	}
 
-	return strings.Split(component, "/")[1], nil
-}
-
-func isEmpty(slice []byte) bool {
-	isZero := true
-	for _, b := range slice {
-		if b != 0 {
-			isZero = false
-			break
+	escaped := false
+	for _, c := range s[1 : len(s)-1] {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			return false
 		}
 	}
 
-	return isZero
+	return !escaped
 }
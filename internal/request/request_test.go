@@ -3,7 +3,10 @@ package request
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/headers"
 	"io"
+	"math/rand/v2"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -33,6 +36,11 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func header(h *headers.Headers, key string) string {
+	value, _ := h.Get(key)
+	return value
+}
+
 const data = "GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"
 
 func TestRequestLineParse(t *testing.T) {
@@ -128,9 +136,9 @@ func TestStandardHeadersParse(t *testing.T) {
 	r, err := FromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", header(r.Headers, "host"))
+	assert.Equal(t, "curl/7.81.0", header(r.Headers, "user-agent"))
+	assert.Equal(t, "*/*", header(r.Headers, "accept"))
 }
 
 func TestDuplicateHeadersParse(t *testing.T) {
@@ -141,8 +149,8 @@ func TestDuplicateHeadersParse(t *testing.T) {
 	r, err := FromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:41000", r.Headers["host"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069", "localhost:41000"}, r.Headers.Values("host"))
+	assert.Equal(t, "*/*", header(r.Headers, "accept"))
 }
 
 func TestCaseInsensitiveHeadersParse(t *testing.T) {
@@ -153,8 +161,8 @@ func TestCaseInsensitiveHeadersParse(t *testing.T) {
 	r, err := FromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069, localhost:41000", r.Headers["host"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, []string{"localhost:42069", "localhost:41000"}, r.Headers.Values("host"))
+	assert.Equal(t, "*/*", header(r.Headers, "accept"))
 }
 
 func TestEmptyHeadersParse(t *testing.T) {
@@ -198,8 +206,10 @@ func TestStandardBodyParsed(t *testing.T) {
 	r, err := FromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
-	assert.Equal(t, "13", r.Headers["content-length"])
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, "13", header(r.Headers, "content-length"))
 }
 
 func TestEmptyBodyParsed(t *testing.T) {
@@ -212,8 +222,10 @@ func TestEmptyBodyParsed(t *testing.T) {
 	r, err := FromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
-	if _, ok := r.Headers["content-length"]; ok {
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
+	if _, ok := r.Headers.Get("content-length"); ok {
 		t.Error("Content-Length header should not be present")
 	}
 }
@@ -229,8 +241,10 @@ func TestEmptyBodyWithContentLength0Parsed(t *testing.T) {
 	r, err := FromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
-	assert.Equal(t, "0", r.Headers["content-length"])
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
+	assert.Equal(t, "0", header(r.Headers, "content-length"))
 }
 
 func TestFaultyBodyFailing(t *testing.T) {
@@ -242,7 +256,9 @@ func TestFaultyBodyFailing(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err := FromReader(reader)
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
 	require.Error(t, err)
 }
 
@@ -255,6 +271,506 @@ func TestMismatchingContentAndContentLengthFails(t *testing.T) {
 			"hello world!\n",
 		numBytesPerRead: 3,
 	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
+	require.Error(t, err)
+}
+
+func TestChunkedBodyParsed(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\n" +
+			"hello \r\n" +
+			"7\r\n" +
+			"world!\n\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Empty(t, r.Trailers)
+}
+
+func TestChunkedBodyWithExtensionsAndTrailersParsed(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: gzip, chunked\r\n" +
+			"\r\n" +
+			"A;name=value;flag\r\n" +
+			"0123456789\r\n" +
+			"1 ; quoted=\"a;b\"\r\n" +
+			"!\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789!", string(body))
+	assert.Equal(t, "abc", header(r.Trailers, "x-checksum"))
+}
+
+func TestChunkedBodyFailing(t *testing.T) {
+	// Test: Invalid chunk size
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"zz\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
+	require.Error(t, err)
+
+	// Test: Chunk longer than its declared size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
+	require.Error(t, err)
+
+	// Test: Missing terminating chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = FromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
+	require.Error(t, err)
+
+	// Test: Transfer coding not ending in chunked
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Transfer-Encoding: chunked, gzip\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = FromReader(reader)
+	require.Error(t, err)
+}
+
+func TestContentLengthWithTransferEncodingFails(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
 	_, err := FromReader(reader)
 	require.Error(t, err)
 }
+
+func TestBodyIsStreamed(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	// Only the bytes needed for the headers have been read so far
+	assert.Less(t, reader.pos, len(reader.data))
+
+	part := make([]byte, 5)
+	n, err := io.ReadFull(r.Body, part)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(part[:n]))
+
+	rest, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, " world!\n", string(rest))
+	require.NoError(t, r.Body.Close())
+}
+
+func TestReadBodyCapFailing(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody(12)
+	require.Error(t, err)
+}
+
+func TestLeftoverAfterBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"helloGET / HTTP/1.1\r\n\r\n",
+		numBytesPerRead: len("POST /submit HTTP/1.1\r\nContent-Length: 5\r\n\r\nhelloGET"),
+	}
+	r, err := FromReader(reader)
+	require.NoError(t, err)
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.NotEmpty(t, r.Leftover())
+	assert.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(r.Leftover())+reader.data[reader.pos:])
+}
+
+func TestErrorsCarryStatusCode(t *testing.T) {
+	requireStatus := func(t *testing.T, err error, statusCode int) {
+		var statusErr StatusError
+		require.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, statusCode, statusErr.StatusCode())
+	}
+
+	// Test: Malformed request line
+	_, err := FromReader(strings.NewReader("GET /\r\n\r\n"))
+	var lineErr *RequestLineError
+	require.ErrorAs(t, err, &lineErr)
+	requireStatus(t, err, 400)
+
+	// Test: Unknown method
+	_, err = FromReader(strings.NewReader("BREW /pot HTTP/1.1\r\n\r\n"))
+	var methodErr *MethodError
+	require.ErrorAs(t, err, &methodErr)
+	assert.Equal(t, "BREW", methodErr.Method)
+	requireStatus(t, err, 501)
+
+	// Test: Unsupported version
+	_, err = FromReader(strings.NewReader("GET / HTTP/2.0\r\n\r\n"))
+	var versionErr *VersionError
+	require.ErrorAs(t, err, &versionErr)
+	requireStatus(t, err, 505)
+
+	// Test: Malformed header
+	_, err = FromReader(strings.NewReader("GET / HTTP/1.1\r\nHost : localhost\r\n\r\n"))
+	var headerErr *HeaderError
+	require.ErrorAs(t, err, &headerErr)
+	requireStatus(t, err, 400)
+
+	// Test: Bad content length
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n"))
+	var lengthErr *ContentLengthError
+	require.ErrorAs(t, err, &lengthErr)
+	requireStatus(t, err, 400)
+
+	// Test: Transfer encoding not ending in chunked, the body length is unknown
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n"))
+	requireStatus(t, err, 400)
+
+	// Test: Unsupported transfer coding in strict mode
+	_, err = FromReaderWithOptions(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n"), Options{Strict: true})
+	var encodingErr *TransferEncodingError
+	require.ErrorAs(t, err, &encodingErr)
+	requireStatus(t, err, 501)
+
+	// Test: Both Content-Length and Transfer-Encoding
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n"))
+	var framingErr *FramingError
+	require.ErrorAs(t, err, &framingErr)
+	requireStatus(t, err, 400)
+
+	// Test: Truncated headers
+	_, err = FromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	requireStatus(t, err, 400)
+
+	// Test: Body over the cap
+	r, err := FromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	_, err = r.ReadBody(4)
+	var tooLargeErr *BodyTooLargeError
+	require.ErrorAs(t, err, &tooLargeErr)
+	assert.Equal(t, int64(4), tooLargeErr.Limit)
+	requireStatus(t, err, 413)
+}
+
+func TestHttpVersions(t *testing.T) {
+	// Test: HTTP/1.0 is accepted
+	r, err := FromReader(strings.NewReader("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.Equal(t, 1, r.RequestLine.ProtoMajor)
+	assert.Equal(t, 0, r.RequestLine.ProtoMinor)
+	assert.False(t, r.RequestLine.ProtoAtLeast(1, 1))
+
+	r, err = FromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.RequestLine.ProtoAtLeast(1, 1))
+	assert.True(t, r.RequestLine.ProtoAtLeast(1, 0))
+
+	// Test: Other versions are unsupported
+	for _, version := range []string{"HTTP/0.9", "HTTP/2.0", "HTTP/3.0"} {
+		_, err = FromReader(strings.NewReader("GET / " + version + "\r\n\r\n"))
+		var versionErr *VersionError
+		require.ErrorAs(t, err, &versionErr, version)
+		assert.Equal(t, 505, versionErr.StatusCode())
+	}
+}
+
+func TestRepeatedContentLength(t *testing.T) {
+	// Test: Identical values are accepted
+	r, err := FromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Conflicting values are rejected
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!"))
+	var lengthErr *ContentLengthError
+	require.ErrorAs(t, err, &lengthErr)
+
+	_, err = FromReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 5, 6\r\n\r\nhello!"))
+	require.ErrorAs(t, err, &lengthErr)
+}
+
+func TestObsFoldOption(t *testing.T) {
+	data := "GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: first\r\n second\r\n\r\n"
+
+	_, err := FromReader(strings.NewReader(data))
+	var headerErr *HeaderError
+	require.ErrorAs(t, err, &headerErr)
+	assert.Equal(t, 400, headerErr.StatusCode())
+
+	r, err := FromReaderWithOptions(strings.NewReader(data), Options{ObsFold: headers.ReplaceObsFold})
+	require.NoError(t, err)
+	assert.Equal(t, "first second", header(r.Headers, "X-Long"))
+}
+
+// endlessReader serves prefix and then repeats filler forever
+type endlessReader struct {
+	prefix string
+	filler byte
+}
+
+func (e *endlessReader) Read(p []byte) (int, error) {
+	n := copy(p, e.prefix)
+	e.prefix = e.prefix[n:]
+	for i := n; i < len(p); i++ {
+		p[i] = e.filler
+	}
+
+	return len(p), nil
+}
+
+func TestRequestLineLimit(t *testing.T) {
+	options := Options{MaxRequestLineBytes: 32}
+
+	_, err := FromReaderWithOptions(strings.NewReader("GET /"+strings.Repeat("a", 64)+" HTTP/1.1\r\n\r\n"), options)
+	var lineErr *RequestLineTooLongError
+	require.ErrorAs(t, err, &lineErr)
+	assert.Equal(t, 414, lineErr.StatusCode())
+
+	// Test: A line that never ends is cut without being buffered
+	_, err = FromReaderWithOptions(&endlessReader{prefix: "GET /", filler: 'a'}, options)
+	require.ErrorAs(t, err, &lineErr)
+
+	// Test: The default applies when no limit is given
+	_, err = FromReader(&endlessReader{prefix: "GET /", filler: 'a'})
+	require.ErrorAs(t, err, &lineErr)
+	assert.Equal(t, DefaultMaxRequestLineBytes, lineErr.Limit)
+
+	// Test: A line of exactly the limit is fine
+	target := "/" + strings.Repeat("a", 32-len("GET  HTTP/1.1")-1)
+	_, err = FromReaderWithOptions(strings.NewReader("GET "+target+" HTTP/1.1\r\n\r\n"), options)
+	require.NoError(t, err)
+}
+
+func TestHeaderLimits(t *testing.T) {
+	// Test: An endless header line
+	_, err := FromReaderWithOptions(&endlessReader{prefix: "GET / HTTP/1.1\r\nX-Endless: ", filler: 'a'}, Options{MaxHeaderBytes: 1024})
+	var tooLargeErr *HeaderTooLargeError
+	require.ErrorAs(t, err, &tooLargeErr)
+	assert.Equal(t, 431, tooLargeErr.StatusCode())
+
+	// Test: Many short header lines
+	_, err = FromReaderWithOptions(strings.NewReader("GET / HTTP/1.1\r\n"+strings.Repeat("X-Field: value\r\n", 100)+"\r\n"), Options{MaxHeaderBytes: 1024, MaxHeaderCount: -1})
+	require.ErrorAs(t, err, &tooLargeErr)
+
+	// Test: Too many fields
+	_, err = FromReaderWithOptions(strings.NewReader("GET / HTTP/1.1\r\n"+strings.Repeat("X-Field: value\r\n", 11)+"\r\n"), Options{MaxHeaderCount: 10})
+	var countErr *TooManyHeadersError
+	require.ErrorAs(t, err, &countErr)
+	assert.Equal(t, 431, countErr.StatusCode())
+
+	_, err = FromReaderWithOptions(strings.NewReader("GET / HTTP/1.1\r\n"+strings.Repeat("X-Field: value\r\n", 10)+"\r\n"), Options{MaxHeaderCount: 10})
+	require.NoError(t, err)
+
+	// Test: Trailers are limited as well
+	r, err := FromReaderWithOptions(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n"+
+		strings.Repeat("X-Trailer: value\r\n", 11)+"\r\n"), Options{MaxHeaderCount: 10})
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
+	require.ErrorAs(t, err, &countErr)
+}
+
+func TestBodyLimit(t *testing.T) {
+	options := Options{MaxBodyBytes: 8}
+
+	// Test: A declared length over the limit fails before the body is read
+	_, err := FromReaderWithOptions(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 9\r\n\r\n"), options)
+	var tooLargeErr *BodyTooLargeError
+	require.ErrorAs(t, err, &tooLargeErr)
+	assert.Equal(t, 413, tooLargeErr.StatusCode())
+
+	// Test: Chunks adding up over the limit fail while reading
+	r, err := FromReaderWithOptions(strings.NewReader("POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n4\r\n wor\r\n0\r\n\r\n"), options)
+	require.NoError(t, err)
+	_, err = r.ReadBody(0)
+	require.ErrorAs(t, err, &tooLargeErr)
+
+	// Test: Without options an upload past DefaultMaxBodyBytes streams through
+	length := DefaultMaxBodyBytes + 1<<20
+	r, err = FromReader(io.MultiReader(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: "+strconv.FormatInt(length, 10)+"\r\n\r\n"),
+		io.LimitReader(zeros{}, length)))
+	require.NoError(t, err)
+	n, err := io.Copy(io.Discard, r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, length, n)
+
+	// Test: A negative limit lifts it
+	r, err = FromReaderWithOptions(strings.NewReader("POST / HTTP/1.1\r\nContent-Length: 11\r\n\r\nhello world"), Options{MaxBodyBytes: -1})
+	require.NoError(t, err)
+	body, err := r.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+}
+
+// zeros is an endless reader of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func BenchmarkFromReader(b *testing.B) {
+	reader := strings.NewReader(benchmarkRequest)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchmarkRequest)))
+
+	for b.Loop() {
+		reader.Reset(benchmarkRequest)
+		if _, err := FromReader(reader); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// binaryBody returns size random bytes with runs of NUL bytes and framing look-alikes mixed in,
+// the bytes a body reader would trip over if it looked at the content to find the end
+func binaryBody(random *rand.Rand, size int) []byte {
+	body := make([]byte, 0, size)
+	for len(body) < size {
+		switch random.IntN(4) {
+		case 0:
+			body = append(body, make([]byte, random.IntN(64))...)
+		case 1:
+			body = append(body, "\r\n0\r\n\r\n"...)
+		default:
+			for range random.IntN(64) {
+				body = append(body, byte(random.IntN(256)))
+			}
+		}
+	}
+
+	return body[:size]
+}
+
+// chunked frames body as chunks of random sizes
+func chunked(random *rand.Rand, body []byte) string {
+	var b strings.Builder
+	for len(body) > 0 {
+		size := min(len(body), 1+random.IntN(300))
+		b.WriteString(strconv.FormatInt(int64(size), 16) + "\r\n")
+		b.Write(body[:size])
+		b.WriteString("\r\n")
+		body = body[size:]
+	}
+	b.WriteString("0\r\n\r\n")
+
+	return b.String()
+}
+
+func TestBinaryBodyRoundTrips(t *testing.T) {
+	random := rand.New(rand.NewPCG(20, 20))
+	body := binaryBody(random, 1500)
+	next := "GET /next HTTP/1.1\r\n\r\n"
+
+	requests := map[string]string{
+		"content-length": "POST /upload HTTP/1.1\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + string(body) + next,
+		"chunked":        "POST /upload HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + chunked(random, body) + next,
+	}
+
+	// Test: Every byte of the body comes back at every read size, and nothing past its end
+	for name, raw := range requests {
+		for size := 1; size <= len(raw); size++ {
+			r, err := FromReader(&chunkReader{data: raw, numBytesPerRead: size})
+			require.NoError(t, err, "%s, %d bytes per read", name, size)
+			read, err := r.ReadBody(0)
+			require.NoError(t, err, "%s, %d bytes per read", name, size)
+			require.Equal(t, body, read, "%s, %d bytes per read", name, size)
+
+			// The reader may stop anywhere in the next request, what was read of it is kept
+			leftover := string(r.Leftover())
+			require.True(t, strings.HasPrefix(next, leftover), "%s, %d bytes per read: %q", name, size, leftover)
+		}
+	}
+}
+
+func TestBinaryBodyCutShort(t *testing.T) {
+	random := rand.New(rand.NewPCG(20, 21))
+	body := binaryBody(random, 512)
+	raw := "POST /upload HTTP/1.1\r\nContent-Length: " + strconv.Itoa(len(body)+1) + "\r\n\r\n" + string(body)
+
+	// Test: The end of the input is never taken for the end of the body, however the bytes arrive
+	for size := 1; size <= len(raw); size++ {
+		r, err := FromReader(&chunkReader{data: raw, numBytesPerRead: size})
+		require.NoError(t, err)
+		_, err = r.ReadBody(0)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF, "%d bytes per read", size)
+	}
+}
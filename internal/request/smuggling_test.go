@@ -0,0 +1,195 @@
+package request
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"strings"
+	"testing"
+)
+
+// parseAll parses the request and reads its body, returning the first error met
+func parseAll(payload string, options Options) error {
+	r, err := FromReaderWithOptions(strings.NewReader(payload), options)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.ReadBody(0)
+	return err
+}
+
+func TestSmugglingPayloadsAreRejected(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload string
+		want    error
+	}{
+		{
+			name:    "CL.CL conflicting values",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+			want:    ErrConflictingContentLength,
+		},
+		{
+			name:    "CL comma list with conflicting values",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 6\r\n\r\nhello!",
+			want:    ErrConflictingContentLength,
+		},
+		{
+			name:    "CL repeated with the same value",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+			want:    ErrRepeatedContentLength,
+		},
+		{
+			name:    "CL comma list with the same value",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5,5\r\n\r\nhello",
+			want:    ErrRepeatedContentLength,
+		},
+		{
+			name:    "CL with a plus sign",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello",
+			want:    ErrInvalidContentLength,
+		},
+		{
+			name:    "CL with a minus sign",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -0\r\n\r\n",
+			want:    ErrInvalidContentLength,
+		},
+		{
+			name:    "CL in hex",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+			want:    ErrInvalidContentLength,
+		},
+		{
+			name:    "CL with inner whitespace",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 1 5\r\n\r\nhello",
+			want:    ErrInvalidContentLength,
+		},
+		{
+			name:    "CL overflowing int64",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999\r\n\r\n",
+			want:    ErrInvalidContentLength,
+		},
+		{
+			name:    "CL.TE",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nX",
+			want:    ErrContentLengthWithTransferEncoding,
+		},
+		{
+			name:    "TE.CL",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n1\r\nX\r\n0\r\n\r\n",
+			want:    ErrContentLengthWithTransferEncoding,
+		},
+		{
+			name:    "TE not ending in chunked",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+			want:    ErrChunkedNotLast,
+		},
+		{
+			name:    "TE chunked twice",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, chunked\r\n\r\n0\r\n\r\n",
+			want:    ErrChunkedNotLast,
+		},
+		{
+			name:    "TE split over two fields",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\n\r\n0\r\n\r\n",
+			want:    ErrChunkedNotLast,
+		},
+		{
+			name:    "TE with a look-alike coding",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+			want:    ErrChunkedNotLast,
+		},
+		{
+			name:    "TE empty",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: \r\n\r\n",
+			want:    ErrChunkedNotLast,
+		},
+		{
+			name:    "TE with another coding before chunked",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			want:    ErrUnsupportedTransferCoding,
+		},
+		{
+			name:    "Space before colon",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\nContent-Length: 5\r\n\r\nhello",
+			want:    ErrWhitespaceBeforeColon,
+		},
+		{
+			name:    "Tab before colon",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length\t: 5\r\n\r\nhello",
+			want:    ErrWhitespaceBeforeColon,
+		},
+		{
+			name:    "Bare LF inside the header section",
+			payload: "POST / HTTP/1.1\r\nHost: a\nContent-Length: 5\r\n\r\nhello",
+			want:    ErrBareLF,
+		},
+		{
+			name:    "Bare LF line endings only",
+			payload: "POST / HTTP/1.1\nHost: a\nContent-Length: 5\n\nhello",
+			want:    ErrBareLF,
+		},
+		{
+			name:    "Bare LF after the chunk size",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n",
+			want:    ErrBareLF,
+		},
+		{
+			name:    "Whitespace after the chunk size",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5 \r\nhello\r\n0\r\n\r\n",
+			want:    ErrWhitespaceAfterChunkSize,
+		},
+		{
+			name:    "Folded Transfer-Encoding",
+			payload: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: identity\r\n chunked\r\n\r\n0\r\n\r\n",
+			want:    ErrObsFold,
+		},
+		{
+			name:    "Repeated Host",
+			payload: "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+			want:    ErrRepeatedHost,
+		},
+		{
+			name:    "Transfer-Encoding in HTTP/1.0",
+			payload: "POST / HTTP/1.0\r\nHost: a\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+			want:    ErrTransferEncodingInHTTP10,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// Folding is rejected even when the lenient policy is asked for
+			err := parseAll(tc.payload, Options{Strict: true, ObsFold: headers.ReplaceObsFold})
+			require.ErrorIs(t, err, tc.want)
+
+			var statusErr StatusError
+			require.ErrorAs(t, err, &statusErr)
+			assert.GreaterOrEqual(t, statusErr.StatusCode(), 400)
+		})
+	}
+}
+
+func TestSmugglingPayloadsWithoutStrictMode(t *testing.T) {
+	// Test: Ambiguities every parser resolves the same way are let through
+	require.NoError(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 5\r\n\r\nhello", Options{}))
+	require.NoError(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n", Options{}))
+	require.NoError(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5 \r\nhello\r\n0\r\n\r\n", Options{}))
+	// Test: The server closes the connection after answering it instead
+	require.NoError(t, parseAll("POST / HTTP/1.0\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", Options{}))
+
+	// Test: Anything that could desync is rejected regardless
+	require.ErrorIs(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello", Options{}), ErrInvalidContentLength)
+	require.ErrorIs(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n", Options{}), ErrChunkedNotLast)
+	require.ErrorIs(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nContent-Length : 5\r\n\r\nhello", Options{}), ErrWhitespaceBeforeColon)
+	require.ErrorIs(t, parseAll("POST / HTTP/1.1\nHost: a\n\n", Options{}), ErrBareLF)
+	var framingErr *FramingError
+	require.ErrorAs(t, parseAll("POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n+5\r\nhello\r\n0\r\n\r\n", Options{}), &framingErr)
+}
+
+func TestStrictRequestLine(t *testing.T) {
+	_, err := FromReaderWithOptions(strings.NewReader(" GET / HTTP/1.1\r\nHost: a\r\n\r\n"), Options{Strict: true})
+	var lineErr *RequestLineError
+	require.ErrorAs(t, err, &lineErr)
+
+	_, err = FromReaderWithOptions(strings.NewReader("GET / HTTP/1.1 \r\nHost: a\r\n\r\n"), Options{Strict: true})
+	require.ErrorAs(t, err, &lineErr)
+}
@@ -0,0 +1,364 @@
+package request
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TargetForm is one of the four request-target forms of RFC 9112 section 3.2
+type TargetForm int
+
+const (
+	// OriginForm is an absolute path with an optional query, e.g. /search?q=x
+	OriginForm TargetForm = iota
+	// AbsoluteForm is a full URI, as sent to proxies, e.g. http://example.com/search?q=x
+	AbsoluteForm
+	// AuthorityForm is a host and port, only used by CONNECT, e.g. example.com:443
+	AuthorityForm
+	// AsteriskForm is a lone *, only used by server-wide OPTIONS requests
+	AsteriskForm
+)
+
+// URL is the parsed request-target
+type URL struct {
+	Form   TargetForm
+	Scheme string
+	// Host is the authority of absolute-form and authority-form targets, host[:port]
+	Host string
+	// Path is the percent-decoded path with its dot segments removed. Encoded slashes, backslashes and percent
+	// signs are left encoded, so that every separator in Path was one in the target and no segment decodes to "..".
+	Path string
+	// RawPath is Path before percent-decoding
+	RawPath  string
+	RawQuery string
+}
+
+// Query is the parsed query string, a key may be given several values
+type Query map[string][]string
+
+// Get returns the first value of key, or "" when there is none
+func (q Query) Get(key string) string {
+	if values := q[key]; len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// Values returns every value of key, in the order they appeared
+func (q Query) Values(key string) []string {
+	return q[key]
+}
+
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+const (
+	unreservedChars = "-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	subDelimChars   = "!$&'()*+,;="
+	hexDigits       = "0123456789ABCDEF"
+)
+
+// ParseTarget parses the request-target of a request sent with method, see RFC 9112 section 3.2
+func ParseTarget(method, target string) (*URL, error) {
+	switch {
+	case target == "":
+		return nil, invalidTarget(target)
+	case target == "*":
+		if method != http.MethodOptions {
+			return nil, invalidTarget(target)
+		}
+		return &URL{Form: AsteriskForm}, nil
+	case method == http.MethodConnect:
+		if !isAuthority(target) {
+			return nil, invalidTarget(target)
+		}
+		return &URL{Form: AuthorityForm, Host: target}, nil
+	case target[0] == '/':
+		return parseOriginForm(target)
+	}
+
+	return parseAbsoluteForm(target)
+}
+
+// Query parses RawQuery, decoding '+' as a space. Pairs without '=' get an empty value.
+func (u *URL) Query() Query {
+	query := make(Query)
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+		key, _ = unescape(strings.ReplaceAll(key, "+", " "))
+		value, _ = unescape(strings.ReplaceAll(value, "+", " "))
+		query[key] = append(query[key], value)
+	}
+
+	return query
+}
+
+// String returns the target in the form it was sent, with the path normalised
+func (u *URL) String() string {
+	var b strings.Builder
+	switch u.Form {
+	case AsteriskForm:
+		return "*"
+	case AuthorityForm:
+		return u.Host
+	case AbsoluteForm:
+		b.WriteString(u.Scheme + "://" + u.Host)
+	}
+
+	b.WriteString(u.RawPath)
+	if u.RawQuery != "" {
+		b.WriteString("?" + u.RawQuery)
+	}
+
+	return b.String()
+}
+
+func parseOriginForm(target string) (*URL, error) {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	if !validChars(rawPath, "/:@") || !validChars(rawQuery, "/:@?") {
+		return nil, invalidTarget(target)
+	}
+
+	rawPath = removeDotSegments(normalizeEscapes(rawPath))
+	path, err := unescapePath(rawPath)
+	if err != nil || strings.ContainsRune(path, 0) {
+		return nil, invalidTarget(target)
+	}
+
+	return &URL{Form: OriginForm, Path: path, RawPath: rawPath, RawQuery: rawQuery}, nil
+}
+
+func parseAbsoluteForm(target string) (*URL, error) {
+	scheme, rest, found := strings.Cut(target, "://")
+	if !found || !isScheme(scheme) {
+		return nil, invalidTarget(target)
+	}
+
+	end := strings.IndexAny(rest, "/?")
+	if end == -1 {
+		end = len(rest)
+	}
+	host := rest[:end]
+	if host == "" || !isAuthority(host) {
+		return nil, invalidTarget(target)
+	}
+
+	// An empty path stands for the root, RFC 9112 section 3.2.2
+	path := rest[end:]
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	u, err := parseOriginForm(path)
+	if err != nil {
+		return nil, invalidTarget(target)
+	}
+
+	u.Form = AbsoluteForm
+	u.Scheme = strings.ToLower(scheme)
+	u.Host = host
+	return u, nil
+}
+
+// isAuthority checks host[:port], where host is a registered name, an IPv4 address or a bracketed IPv6 address
+func isAuthority(s string) bool {
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		closing := strings.Index(s, "]")
+		if closing == -1 || !validChars(s[1:closing], ":.") || closing == 1 {
+			return false
+		}
+		host, port = s[:closing+1], s[closing+1:]
+		if port != "" && port[0] != ':' {
+			return false
+		}
+		port = strings.TrimPrefix(port, ":")
+	} else if i := strings.LastIndex(s, ":"); i != -1 {
+		host, port = s[:i], s[i+1:]
+	}
+
+	if host == "" || (!strings.HasPrefix(host, "[") && (strings.ContainsAny(host, ":@") || !validChars(host, ""))) {
+		return false
+	}
+
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isScheme(s string) bool {
+	if s == "" || !isAlpha(s[0]) {
+		return false
+	}
+
+	for i := 1; i < len(s); i++ {
+		if !isAlpha(s[i]) && !(s[i] >= '0' && s[i] <= '9') && !strings.ContainsRune("+-.", rune(s[i])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// validChars reports whether s only holds unreserved characters, sub-delims, well-formed
+// percent-encodings and the given extra characters
+func validChars(s string, extra string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return false
+			}
+			i += 2
+		case strings.IndexByte(unreservedChars, c) != -1, strings.IndexByte(subDelimChars, c) != -1, strings.IndexByte(extra, c) != -1:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeEscapes decodes percent-encoded unreserved characters and upper-cases the remaining escapes,
+// as RFC 3986 section 6.2.2 considers them equivalent. It makes %2E%2E a dot segment like "..".
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if strings.IndexByte(unreservedChars, c) != -1 {
+			b.WriteByte(c)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0xf])
+		}
+		i += 2
+	}
+
+	return b.String()
+}
+
+// removeDotSegments resolves "." and ".." segments, never climbing above the root, RFC 3986 section 5.2.4
+func removeDotSegments(path string) string {
+	if !strings.Contains(path, "/.") {
+		return path
+	}
+
+	segments := strings.Split(path[1:], "/")
+	output := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+			if last {
+				output = append(output, "")
+			}
+		case "..":
+			if len(output) > 0 {
+				output = output[:len(output)-1]
+			}
+			if last {
+				output = append(output, "")
+			}
+		default:
+			output = append(output, segment)
+		}
+	}
+
+	return "/" + strings.Join(output, "/")
+}
+
+// unescape decodes every percent-encoding in s
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", invalidTarget(s)
+		}
+		b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+// unescapePath is unescape for a path, leaving %2F, %5C and %25 encoded. Decoding %25 would let %252F
+// turn into the %2F of an encoded slash.
+func unescapePath(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			return "", invalidTarget(s)
+		}
+		if c := unhex(s[i+1])<<4 | unhex(s[i+2]); c == '/' || c == '\\' || c == '%' {
+			b.WriteString(s[i : i+3])
+		} else {
+			b.WriteByte(c)
+		}
+		i += 2
+	}
+
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+
+	return c - 'A' + 10
+}
+
+func invalidTarget(target string) error {
+	return &TargetError{Target: target}
+}
@@ -0,0 +1,113 @@
+package request
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseTargetOriginForm(t *testing.T) {
+	u, err := ParseTarget("GET", "/search?q=go+lang&tag=a&tag=b%26c&empty&")
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, u.Form)
+	assert.Equal(t, "/search", u.Path)
+	assert.Equal(t, "q=go+lang&tag=a&tag=b%26c&empty&", u.RawQuery)
+
+	query := u.Query()
+	assert.Equal(t, "go lang", query.Get("q"))
+	assert.Equal(t, []string{"a", "b&c"}, query.Values("tag"))
+	assert.True(t, query.Has("empty"))
+	assert.Equal(t, "", query.Get("missing"))
+
+	// Test: Empty segments are kept
+	u, err = ParseTarget("GET", "/a//b")
+	require.NoError(t, err)
+	assert.Equal(t, "/a//b", u.Path)
+
+	// Test: Percent-encodings are decoded in Path only, but for slashes, backslashes and percent signs
+	u, err = ParseTarget("GET", "/files/my%20file%2fname%5c")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/my file%2Fname%5C", u.Path)
+	assert.Equal(t, "/files/my%20file%2Fname%5C", u.RawPath)
+
+	// Test: Encoded slashes neither make dot segments nor separators once decoded
+	u, err = ParseTarget("GET", "/..%2F..%2Fetc")
+	require.NoError(t, err)
+	assert.Equal(t, "/..%2F..%2Fetc", u.Path)
+	u, err = ParseTarget("GET", "/files/a%2Fb")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a%2Fb", u.Path)
+
+	// Test: An encoded percent sign does not make an encoded slash
+	u, err = ParseTarget("GET", "/a%252F")
+	require.NoError(t, err)
+	assert.Equal(t, "/a%252F", u.Path)
+	assert.NotEqual(t, "/a%2F", u.Path)
+}
+
+func TestParseTargetRemovesDotSegments(t *testing.T) {
+	for target, path := range map[string]string{
+		"/a/b/../c":         "/a/c",
+		"/a/./b/.":          "/a/b/",
+		"/../../etc/passwd": "/etc/passwd",
+		"/a/%2e%2E/b":       "/b",
+		"/a/b/..":           "/a/",
+		"/%7Euser":          "/~user",
+	} {
+		u, err := ParseTarget("GET", target)
+		require.NoError(t, err, target)
+		assert.Equal(t, path, u.Path, target)
+	}
+}
+
+func TestParseTargetOtherForms(t *testing.T) {
+	// Test: Absolute-form
+	u, err := ParseTarget("GET", "HTTP://example.com:8080/a/../b?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, AbsoluteForm, u.Form)
+	assert.Equal(t, "http", u.Scheme)
+	assert.Equal(t, "example.com:8080", u.Host)
+	assert.Equal(t, "/b", u.Path)
+	assert.Equal(t, "http://example.com:8080/b?x=1", u.String())
+
+	u, err = ParseTarget("GET", "http://[::1]")
+	require.NoError(t, err)
+	assert.Equal(t, "[::1]", u.Host)
+	assert.Equal(t, "/", u.Path)
+
+	// Test: Authority-form is only for CONNECT
+	u, err = ParseTarget("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, u.Form)
+	assert.Equal(t, "example.com:443", u.Host)
+	_, err = ParseTarget("CONNECT", "/path")
+	require.Error(t, err)
+
+	// Test: Asterisk-form is only for OPTIONS
+	u, err = ParseTarget("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, u.Form)
+	_, err = ParseTarget("GET", "*")
+	require.Error(t, err)
+}
+
+func TestParseTargetRejectsInvalidTargets(t *testing.T) {
+	for _, target := range []string{
+		"",
+		"relative/path",
+		"/bad%2",
+		"/bad%zz",
+		"/with#fragment",
+		"/with\"quote",
+		"/nul%00byte",
+		"/caf\xc3\xa9",
+		"http://",
+		"http://exa mple.com/",
+		"http://host:port/",
+	} {
+		_, err := ParseTarget("GET", target)
+		var targetErr *TargetError
+		require.ErrorAs(t, err, &targetErr, target)
+		assert.Equal(t, 400, targetErr.StatusCode())
+	}
+}
@@ -0,0 +1,164 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// New builds a request to send, e.g. with the client package. The target is usually in absolute-form,
+// its authority giving the Host header. A body of known length, a *bytes.Buffer, *bytes.Reader or *strings.Reader,
+// gets a Content-Length, any other one is sent chunked.
+func New(method, target string, body io.Reader) (*Request, error) {
+	method, err := getMethod(method)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := ParseTarget(method, target)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Request{
+		RequestLine: Line{HttpVersion: "1.1", RequestTarget: target, Method: method, ProtoMajor: 1, ProtoMinor: 1},
+		URL:         u,
+		Headers:     headers.New(),
+		Trailers:    headers.New(),
+	}
+	if u.Host != "" {
+		r.Headers.Set("Host", u.Host)
+	}
+
+	switch b := body.(type) {
+	case nil:
+		r.Body = io.NopCloser(bytes.NewReader(nil))
+		return r, nil
+	case *bytes.Buffer:
+		r.Headers.Set("Content-Length", strconv.Itoa(b.Len()))
+	case *bytes.Reader:
+		r.Headers.Set("Content-Length", strconv.Itoa(b.Len()))
+	case *strings.Reader:
+		r.Headers.Set("Content-Length", strconv.Itoa(b.Len()))
+	default:
+		r.Headers.Set("Transfer-Encoding", "chunked")
+	}
+
+	if closer, ok := body.(io.ReadCloser); ok {
+		r.Body = closer
+	} else {
+		r.Body = io.NopCloser(body)
+	}
+
+	return r, nil
+}
+
+// Write sends the request in origin-form, its body framed as its Content-Length or Transfer-Encoding header says.
+// Without either no body is sent. The fields are validated before anything is written.
+func (r *Request) Write(w io.Writer) error {
+	for name, value := range r.Headers.All() {
+		if !headers.ValidName(name) || !headers.ValidValue(value) {
+			return fmt.Errorf("error: invalid header %q: %q", name, value)
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(writer, "%s %s HTTP/1.1\r\n", r.RequestLine.Method, r.originForm()); err != nil {
+		return err
+	}
+	if err := writeFields(writer, r.Headers); err != nil {
+		return err
+	}
+
+	var err error
+	switch contentLength, hasLength := r.Headers.Get("Content-Length"); {
+	case r.Headers.ContainsToken("Transfer-Encoding", "chunked"):
+		err = r.writeChunked(writer)
+	case hasLength:
+		err = r.writeLength(writer, contentLength)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func (r *Request) originForm() string {
+	switch r.URL.Form {
+	case AbsoluteForm:
+		if r.URL.RawQuery != "" {
+			return r.URL.RawPath + "?" + r.URL.RawQuery
+		}
+		return r.URL.RawPath
+	case AsteriskForm:
+		return "*"
+	case AuthorityForm:
+		return r.URL.Host
+	}
+
+	return r.RequestLine.RequestTarget
+}
+
+func (r *Request) writeLength(writer *bufio.Writer, contentLength string) error {
+	length, err := strconv.ParseInt(contentLength, 10, 64)
+	if err != nil || length < 0 {
+		return fmt.Errorf("error: invalid content length: %s", contentLength)
+	}
+
+	n, err := io.Copy(writer, io.LimitReader(r.Body, length))
+	if err != nil {
+		return err
+	}
+	if n < length {
+		return fmt.Errorf("error: body of %d bytes shorter than its Content-Length of %d", n, length)
+	}
+
+	return nil
+}
+
+func (r *Request) writeChunked(writer *bufio.Writer) error {
+	buffer := make([]byte, bodyBufferSize)
+	for {
+		n, err := r.Body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := fmt.Fprintf(writer, "%x\r\n%s\r\n", n, buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := writer.WriteString("0\r\n"); err != nil {
+		return err
+	}
+
+	for name, value := range r.Trailers.All() {
+		if !headers.ValidName(name) || !headers.ValidValue(value) {
+			return fmt.Errorf("error: invalid trailer %q: %q", name, value)
+		}
+	}
+
+	return writeFields(writer, r.Trailers)
+}
+
+// writeFields writes the field lines followed by the empty line ending the section
+func writeFields(writer *bufio.Writer, fields *headers.Headers) error {
+	for name, value := range fields.All() {
+		if _, err := fmt.Fprintf(writer, "%s: %s\r\n", name, value); err != nil {
+			return err
+		}
+	}
+
+	_, err := writer.WriteString(crlf)
+	return err
+}
@@ -0,0 +1,66 @@
+package request
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNewAndWrite(t *testing.T) {
+	req, err := New("POST", "http://example.com:8080/items?page=2", strings.NewReader("hello world"))
+	require.NoError(t, err)
+	req.Headers.Set("X-Test", "a")
+
+	var b bytes.Buffer
+	require.NoError(t, req.Write(&b))
+	assert.True(t, strings.HasPrefix(b.String(), "POST /items?page=2 HTTP/1.1\r\n"), b.String())
+
+	// Test: What is written parses back to the same request
+	parsed, err := FromReader(&b)
+	require.NoError(t, err)
+	host, _ := parsed.Headers.Get("Host")
+	assert.Equal(t, "example.com:8080", host)
+	contentLength, _ := parsed.Headers.Get("Content-Length")
+	assert.Equal(t, "11", contentLength)
+	body, err := parsed.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+}
+
+func TestWriteChunked(t *testing.T) {
+	req, err := New("PUT", "http://localhost/upload", iotest.OneByteReader(strings.NewReader("abc")))
+	require.NoError(t, err)
+	req.Trailers.Set("X-Checksum", "xyz")
+
+	var b bytes.Buffer
+	require.NoError(t, req.Write(&b))
+	assert.True(t, strings.HasSuffix(b.String(), "\r\n\r\n1\r\na\r\n1\r\nb\r\n1\r\nc\r\n0\r\nX-Checksum: xyz\r\n\r\n"), b.String())
+
+	parsed, err := FromReader(&b)
+	require.NoError(t, err)
+	body, err := parsed.ReadBody(0)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(body))
+	checksum, _ := parsed.Trailers.Get("X-Checksum")
+	assert.Equal(t, "xyz", checksum)
+}
+
+func TestWriteInvalid(t *testing.T) {
+	_, err := New("GET", "not a target", nil)
+	require.Error(t, err)
+
+	// Test: Invalid fields and bodies shorter than their length are refused
+	req, err := New("GET", "http://localhost/", nil)
+	require.NoError(t, err)
+	req.Headers.Set("X-Bad", "a\r\nInjected: yes")
+	require.Error(t, req.Write(io.Discard))
+
+	req, err = New("POST", "http://localhost/", strings.NewReader("short"))
+	require.NoError(t, err)
+	req.Headers.Set("Content-Length", "10")
+	require.Error(t, req.Write(io.Discard))
+}
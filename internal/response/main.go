@@ -5,46 +5,66 @@ import (
 	"github.com/valivishy/httpfromtcp/internal/headers"
 	"io"
 	"strconv"
+	"strings"
 )
 
-type StatusCode int
+// WriteStatusLine writes the status line with the registered reason phrase, which is left empty for unknown codes
+func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
+	return WriteStatusLineWithReason(w, statusCode, StatusText(statusCode))
+}
 
-const (
-	OK                  StatusCode = 200
-	BadRequest          StatusCode = 400
-	InternalServerError StatusCode = 500
-)
+func WriteStatusLineWithReason(w io.Writer, statusCode StatusCode, reason string) error {
+	if !statusCode.Valid() {
+		return fmt.Errorf("error: invalid status code: %d", statusCode)
+	}
 
-func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
-	var message string
-	switch statusCode {
-	case OK:
-		message = "HTTP/1.1 200 OK\r\n"
-	case BadRequest:
-		message = "HTTP/1.1 400 Bad Request\r\n"
-	case InternalServerError:
-		message = "HTTP/1.1 500 Internal Server Error\r\n"
+	if strings.ContainsFunc(reason, isInvalidReasonRune) {
+		return fmt.Errorf("error: invalid reason phrase: %q", reason)
 	}
 
-	if _, err := w.Write([]byte(message)); err != nil {
+	if _, err := fmt.Fprintf(w, "HTTP/1.1 %03d %s\r\n", statusCode, reason); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func GetDefaultHeaders(contentLength int) headers.Headers {
+// isInvalidReasonRune rejects control characters, the reason phrase allows HTAB, SP, VCHAR and obs-text
+func isInvalidReasonRune(r rune) bool {
+	return (r < ' ' && r != '\t') || r == 0x7f
+}
 
-	header := headers.Headers{}
-	header["Content-Type"] = "text/plain"
-	header["Connection"] = "close"
-	header["Content-Length"] = strconv.Itoa(contentLength)
+// GetChunkedHeaders returns the default headers of a body streamed in chunks, its length being unknown upfront.
+// Trailer fields sent after the last chunk are declared in a Trailer header.
+func GetChunkedHeaders(trailers ...string) *headers.Headers {
+	header := headers.New()
+	header.Set("Content-Type", "text/plain")
+	header.Set("Transfer-Encoding", "chunked")
+	if len(trailers) > 0 {
+		header.Set("Trailer", strings.Join(trailers, ", "))
+	}
 
 	return header
 }
 
-func WriteHeaders(w io.Writer, headers headers.Headers) error {
-	for name, value := range headers {
+func GetDefaultHeaders(contentLength int) *headers.Headers {
+	header := headers.New()
+	header.Set("Content-Type", "text/plain")
+	header.Set("Content-Length", strconv.Itoa(contentLength))
+
+	return header
+}
+
+// WriteHeaders writes the fields in order, followed by the blank line ending the header section.
+// Nothing is written when a name is not a token or a value holds CR, LF or other control characters.
+func WriteHeaders(w io.Writer, headers *headers.Headers) error {
+	for name, value := range headers.All() {
+		if err := validateField(name, value); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range headers.All() {
 		if _, err := fmt.Fprintf(w, "%s: %s\r\n", name, value); err != nil {
 			return err
 		}
@@ -55,3 +75,28 @@ func WriteHeaders(w io.Writer, headers headers.Headers) error {
 
 	return nil
 }
+
+// validateField keeps handlers from injecting fields or a body through header names and values
+func validateField(name, value string) error {
+	if !headers.ValidName(name) {
+		return fmt.Errorf("error: invalid header name: %q", name)
+	}
+
+	if !headers.ValidValue(value) {
+		return fmt.Errorf("error: invalid value for header %s: %q", name, value)
+	}
+
+	return nil
+}
+
+// Fields that frame, route or describe the message must be known before the body, RFC 9110 section 6.5.1
+var forbiddenTrailers = map[string]bool{
+	"authorization": true, "cache-control": true, "connection": true, "content-encoding": true,
+	"content-length": true, "content-range": true, "content-type": true, "date": true, "expires": true,
+	"host": true, "location": true, "retry-after": true, "set-cookie": true, "te": true, "trailer": true,
+	"transfer-encoding": true, "vary": true,
+}
+
+func allowedInTrailers(name string) bool {
+	return !forbiddenTrailers[strings.ToLower(name)]
+}
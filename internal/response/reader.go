@@ -0,0 +1,336 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxHeaderBytes caps the status line and headers of a response read by Read, and its trailers
+	MaxHeaderBytes = 1 << 20
+	// MaxHeaderCount caps the number of header fields of a response read by Read, and of its trailers
+	MaxHeaderCount = 100
+
+	maxChunkLineBytes = 4 << 10
+)
+
+var (
+	ErrHeaderTooLarge   = errors.New("error: response header too large")
+	ErrMalformedChunked = errors.New("error: malformed chunked body")
+)
+
+// Response is a response read from a server, e.g. by a proxy or a client
+type Response struct {
+	StatusCode StatusCode
+	Reason     string
+	ProtoMajor int
+	ProtoMinor int
+	Headers    *headers.Headers
+	// Body yields the decoded body, it is empty for responses that cannot have one
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body once Body has been read to EOF
+	Trailers *headers.Headers
+	// ContentLength is the declared length of the body, -1 when it is chunked or ends with the connection
+	ContentLength int64
+	// Chunked reports whether the body came with chunked coding
+	Chunked bool
+	// Close reports whether the server closes the connection after this response, which is then unusable
+	Close bool
+}
+
+// FromReader reads a response to a request sent with method from reader, buffering it unless it is a *bufio.Reader.
+// Bytes buffered past the response are lost, connections reused for several responses need Read with their own reader.
+func FromReader(reader io.Reader, method string) (*Response, error) {
+	buffered, ok := reader.(*bufio.Reader)
+	if !ok {
+		buffered = bufio.NewReader(reader)
+	}
+
+	return Read(buffered, method)
+}
+
+// Read reads a response to a request sent with method, skipping the interim 1xx responses before it.
+// The body is framed following RFC 9112 section 6.3 and read from reader as Body is read.
+func Read(reader *bufio.Reader, method string) (*Response, error) {
+	for {
+		resp, err := readHead(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		// 101 ends HTTP on the connection, it is the final response
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != SwitchingProtocols {
+			continue
+		}
+
+		if err = resp.frameBody(reader, method); err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+// readHead reads the status line and headers
+func readHead(reader *bufio.Reader) (*Response, error) {
+	limit := &headerLimit{remaining: MaxHeaderBytes}
+	line, err := readLine(reader, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{Headers: headers.New(), Trailers: headers.New(), ContentLength: -1}
+	if err = resp.parseStatusLine(strings.TrimSuffix(string(line), "\r\n")); err != nil {
+		return nil, err
+	}
+
+	if err = readFields(reader, resp.Headers, limit); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// parseStatusLine parses HTTP-version SP status-code SP [ reason-phrase ], see RFC 9112 section 4
+func (r *Response) parseStatusLine(line string) error {
+	version, rest, found := strings.Cut(line, " ")
+	if !found {
+		return malformedStatusLine(line)
+	}
+
+	code, reason, _ := strings.Cut(rest, " ")
+	if len(version) != len("HTTP/d.d") || !strings.HasPrefix(version, "HTTP/") || !isDigit(version[5]) ||
+		version[6] != '.' || !isDigit(version[7]) || len(code) != 3 {
+		return malformedStatusLine(line)
+	}
+
+	statusCode, err := strconv.Atoi(code)
+	if err != nil || !StatusCode(statusCode).Valid() {
+		return malformedStatusLine(line)
+	}
+
+	r.ProtoMajor, r.ProtoMinor = int(version[5]-'0'), int(version[7]-'0')
+	r.StatusCode = StatusCode(statusCode)
+	r.Reason = reason
+	return nil
+}
+
+// frameBody decides where the body ends and whether the connection survives it
+func (r *Response) frameBody(reader *bufio.Reader, method string) error {
+	keepAlive := r.ProtoMajor > 1 || r.ProtoMinor >= 1 || r.Headers.ContainsToken("Connection", "keep-alive")
+	r.Close = !keepAlive || r.Headers.ContainsToken("Connection", "close")
+
+	transferEncodings := r.Headers.Values("Transfer-Encoding")
+	contentLength, hasLength := r.Headers.Get("Content-Length")
+	switch {
+	case method == http.MethodHead || !r.StatusCode.AllowsBody() || r.StatusCode == SwitchingProtocols:
+		r.ContentLength = 0
+		if hasLength && method == http.MethodHead {
+			r.ContentLength, _ = strconv.ParseInt(contentLength, 10, 64)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(nil))
+	case len(transferEncodings) > 0:
+		// A body whose last coding is not chunked can only end with the connection
+		codings := strings.Split(strings.Join(transferEncodings, ","), ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			r.Close = true
+			r.Body = io.NopCloser(reader)
+			return nil
+		}
+		r.Chunked = true
+		r.Body = &chunkedBody{reader: reader, trailers: r.Trailers}
+	case hasLength:
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 || len(r.Headers.Values("Content-Length")) > 1 {
+			return fmt.Errorf("error: invalid content length in response: %q", contentLength)
+		}
+		r.ContentLength = length
+		r.Body = &lengthBody{reader: reader, remaining: length}
+	default:
+		r.Close = true
+		r.Body = io.NopCloser(reader)
+	}
+
+	return nil
+}
+
+// lengthBody reads a body of a declared length, an early end of the connection being an error
+type lengthBody struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (b *lengthBody) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	n, err := b.reader.Read(p[:min(int64(len(p)), b.remaining)])
+	b.remaining -= int64(n)
+	if err == io.EOF && b.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (b *lengthBody) Close() error {
+	return nil
+}
+
+// chunkedBody decodes a chunked body, filling trailers once the last chunk is read
+type chunkedBody struct {
+	reader    *bufio.Reader
+	trailers  *headers.Headers
+	remaining int64
+	done      bool
+	err       error
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.remaining == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if b.err = b.nextChunk(); b.err != nil {
+			return 0, b.err
+		}
+		if b.done {
+			return 0, io.EOF
+		}
+	}
+
+	n, err := b.reader.Read(p[:min(int64(len(p)), b.remaining)])
+	b.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && b.remaining == 0 {
+		err = b.chunkEnd()
+	}
+	b.err = err
+
+	return n, err
+}
+
+// nextChunk reads the next chunk size line, and the trailers after the last chunk
+func (b *chunkedBody) nextChunk() error {
+	line, err := readLine(b.reader, &headerLimit{remaining: maxChunkLineBytes})
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	sizePart, _, _ := strings.Cut(strings.TrimSuffix(string(line), "\r\n"), ";")
+	sizePart = strings.TrimRight(sizePart, " \t")
+	size, err := strconv.ParseInt(sizePart, 16, 64)
+	if err != nil || size < 0 || sizePart[0] == '+' || sizePart[0] == '-' {
+		return ErrMalformedChunked
+	}
+
+	if size == 0 {
+		b.done = true
+		return readFields(b.reader, b.trailers, &headerLimit{remaining: MaxHeaderBytes})
+	}
+
+	b.remaining = size
+	return nil
+}
+
+// chunkEnd consumes the CRLF closing the chunk data
+func (b *chunkedBody) chunkEnd() error {
+	var end [2]byte
+	if _, err := io.ReadFull(b.reader, end[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if string(end[:]) != "\r\n" {
+		return ErrMalformedChunked
+	}
+
+	return nil
+}
+
+func (b *chunkedBody) Close() error {
+	return nil
+}
+
+// headerLimit counts the bytes left for a header or trailer section
+type headerLimit struct {
+	remaining int
+}
+
+// readLine reads a line up to and including its LF. It returns io.EOF when the connection ends before
+// the line starts, telling a server closing an idle connection apart from a truncated response.
+func readLine(reader *bufio.Reader, limit *headerLimit) ([]byte, error) {
+	var line []byte
+	for {
+		fragment, err := reader.ReadSlice('\n')
+		limit.remaining -= len(fragment)
+		if limit.remaining < 0 {
+			return nil, ErrHeaderTooLarge
+		}
+		line = append(line, fragment...)
+
+		switch {
+		case err == nil:
+			return line, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err == io.EOF && len(line) == 0:
+			return nil, io.EOF
+		case err == io.EOF:
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+}
+
+// readFields reads field lines into fields up to the empty line ending the section
+func readFields(reader *bufio.Reader, fields *headers.Headers, limit *headerLimit) error {
+	for {
+		line, err := readLine(reader, limit)
+		if err != nil {
+			return unexpectedEOF(err)
+		}
+
+		_, done, err := fields.ParseWithPolicy(line, headers.ReplaceObsFold)
+		if err != nil {
+			return fmt.Errorf("error: invalid response field: %w", err)
+		}
+		if done {
+			return nil
+		}
+		if fields.Len() > MaxHeaderCount {
+			return ErrHeaderTooLarge
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func malformedStatusLine(line string) error {
+	return fmt.Errorf("error: malformed status line: %q", line)
+}
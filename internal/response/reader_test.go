@@ -0,0 +1,127 @@
+package response
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readString(raw, method string) (*Response, error) {
+	return Read(bufio.NewReader(strings.NewReader(raw)), method)
+}
+
+func TestReadContentLength(t *testing.T) {
+	resp, err := readString("HTTP/1.1 200 OK\r\nContent-Length: 5\r\nX-Test: a\r\n\r\nhelloHTTP/1.1 204 No Content\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.Equal(t, OK, resp.StatusCode)
+	assert.Equal(t, "OK", resp.Reason)
+	assert.Equal(t, int64(5), resp.ContentLength)
+	assert.False(t, resp.Close)
+	value, _ := resp.Headers.Get("x-test")
+	assert.Equal(t, "a", value)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: A body cut short is an error
+	resp, err = readString("HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello", "GET")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: FromReader buffers a plain reader itself
+	resp, err = FromReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"), "GET")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+}
+
+func TestReadChunked(t *testing.T) {
+	resp, err := readString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\n\r\n"+
+		"5;ext=1\r\nhello\r\n6\r\n world\r\n0\r\nX-Checksum: abc\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.True(t, resp.Chunked)
+	assert.Equal(t, int64(-1), resp.ContentLength)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+	checksum, _ := resp.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc", checksum)
+
+	// Test: Malformed chunks are errors
+	for _, raw := range []string{"zz\r\nhello\r\n0\r\n\r\n", "5\r\nhelloXX0\r\n\r\n", "-5\r\nhello\r\n0\r\n\r\n", "5\r\nhel"} {
+		resp, err = readString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+raw, "GET")
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.Error(t, err, raw)
+	}
+}
+
+func TestReadBodyFraming(t *testing.T) {
+	// Test: Without length the body ends with the connection
+	resp, err := readString("HTTP/1.1 200 OK\r\n\r\nuntil the end", "GET")
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "until the end", string(body))
+
+	// Test: HEAD responses and body-less status codes have no body whatever their headers say
+	for _, tc := range []struct{ raw, method string }{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", "HEAD"},
+		{"HTTP/1.1 204 No Content\r\nContent-Length: 5\r\n\r\n", "GET"},
+		{"HTTP/1.1 304 Not Modified\r\nTransfer-Encoding: chunked\r\n\r\n", "GET"},
+	} {
+		resp, err = readString(tc.raw+"next", tc.method)
+		require.NoError(t, err)
+		body, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Empty(t, body)
+	}
+
+	// Test: HTTP/1.0 responses close unless asked to keep alive
+	resp, err = readString("HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.True(t, resp.Close)
+	resp, err = readString("HTTP/1.0 200 OK\r\nConnection: keep-alive\r\nContent-Length: 0\r\n\r\n", "GET")
+	require.NoError(t, err)
+	assert.False(t, resp.Close)
+}
+
+func TestReadSkipsInformational(t *testing.T) {
+	resp, err := readString("HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n"+
+		"HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n", "POST")
+	require.NoError(t, err)
+	assert.Equal(t, Created, resp.StatusCode)
+	assert.Equal(t, 0, len(resp.Headers.Values("Link")))
+}
+
+func TestReadMalformed(t *testing.T) {
+	for _, raw := range []string{
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/x.1 200 OK\r\n\r\n",
+		"ICY 200 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nBad Header: x\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nX-Test: a\r\n",
+	} {
+		_, err := readString(raw, "GET")
+		require.Error(t, err, raw)
+	}
+
+	// Test: An empty connection is told apart from a truncated response
+	_, err := readString("", "GET")
+	require.ErrorIs(t, err, io.EOF)
+	_, err = readString("HTTP/1.1 200 OK\r\n", "GET")
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = readString("HTTP/1.1 200 OK\r\nX-Big: "+strings.Repeat("a", MaxHeaderBytes)+"\r\n\r\n", "GET")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+}
@@ -0,0 +1,155 @@
+package response
+
+type StatusCode int
+
+// Status codes registered with IANA, see https://www.iana.org/assignments/http-status-codes
+const (
+	Continue           StatusCode = 100
+	SwitchingProtocols StatusCode = 101
+	Processing         StatusCode = 102
+	EarlyHints         StatusCode = 103
+
+	OK                          StatusCode = 200
+	Created                     StatusCode = 201
+	Accepted                    StatusCode = 202
+	NonAuthoritativeInformation StatusCode = 203
+	NoContent                   StatusCode = 204
+	ResetContent                StatusCode = 205
+	PartialContent              StatusCode = 206
+	MultiStatus                 StatusCode = 207
+	AlreadyReported             StatusCode = 208
+	IMUsed                      StatusCode = 226
+
+	MultipleChoices   StatusCode = 300
+	MovedPermanently  StatusCode = 301
+	Found             StatusCode = 302
+	SeeOther          StatusCode = 303
+	NotModified       StatusCode = 304
+	UseProxy          StatusCode = 305
+	TemporaryRedirect StatusCode = 307
+	PermanentRedirect StatusCode = 308
+
+	BadRequest                  StatusCode = 400
+	Unauthorized                StatusCode = 401
+	PaymentRequired             StatusCode = 402
+	Forbidden                   StatusCode = 403
+	NotFound                    StatusCode = 404
+	MethodNotAllowed            StatusCode = 405
+	NotAcceptable               StatusCode = 406
+	ProxyAuthenticationRequired StatusCode = 407
+	RequestTimeout              StatusCode = 408
+	Conflict                    StatusCode = 409
+	Gone                        StatusCode = 410
+	LengthRequired              StatusCode = 411
+	PreconditionFailed          StatusCode = 412
+	ContentTooLarge             StatusCode = 413
+	URITooLong                  StatusCode = 414
+	UnsupportedMediaType        StatusCode = 415
+	RangeNotSatisfiable         StatusCode = 416
+	ExpectationFailed           StatusCode = 417
+	MisdirectedRequest          StatusCode = 421
+	UnprocessableContent        StatusCode = 422
+	Locked                      StatusCode = 423
+	FailedDependency            StatusCode = 424
+	TooEarly                    StatusCode = 425
+	UpgradeRequired             StatusCode = 426
+	PreconditionRequired        StatusCode = 428
+	TooManyRequests             StatusCode = 429
+	RequestHeaderFieldsTooLarge StatusCode = 431
+	UnavailableForLegalReasons  StatusCode = 451
+
+	InternalServerError           StatusCode = 500
+	NotImplemented                StatusCode = 501
+	BadGateway                    StatusCode = 502
+	ServiceUnavailable            StatusCode = 503
+	GatewayTimeout                StatusCode = 504
+	HTTPVersionNotSupported       StatusCode = 505
+	VariantAlsoNegotiates         StatusCode = 506
+	InsufficientStorage           StatusCode = 507
+	LoopDetected                  StatusCode = 508
+	NotExtended                   StatusCode = 510
+	NetworkAuthenticationRequired StatusCode = 511
+)
+
+var reasonPhrases = map[StatusCode]string{
+	Continue:           "Continue",
+	SwitchingProtocols: "Switching Protocols",
+	Processing:         "Processing",
+	EarlyHints:         "Early Hints",
+
+	OK:                          "OK",
+	Created:                     "Created",
+	Accepted:                    "Accepted",
+	NonAuthoritativeInformation: "Non-Authoritative Information",
+	NoContent:                   "No Content",
+	ResetContent:                "Reset Content",
+	PartialContent:              "Partial Content",
+	MultiStatus:                 "Multi-Status",
+	AlreadyReported:             "Already Reported",
+	IMUsed:                      "IM Used",
+
+	MultipleChoices:   "Multiple Choices",
+	MovedPermanently:  "Moved Permanently",
+	Found:             "Found",
+	SeeOther:          "See Other",
+	NotModified:       "Not Modified",
+	UseProxy:          "Use Proxy",
+	TemporaryRedirect: "Temporary Redirect",
+	PermanentRedirect: "Permanent Redirect",
+
+	BadRequest:                  "Bad Request",
+	Unauthorized:                "Unauthorized",
+	PaymentRequired:             "Payment Required",
+	Forbidden:                   "Forbidden",
+	NotFound:                    "Not Found",
+	MethodNotAllowed:            "Method Not Allowed",
+	NotAcceptable:               "Not Acceptable",
+	ProxyAuthenticationRequired: "Proxy Authentication Required",
+	RequestTimeout:              "Request Timeout",
+	Conflict:                    "Conflict",
+	Gone:                        "Gone",
+	LengthRequired:              "Length Required",
+	PreconditionFailed:          "Precondition Failed",
+	ContentTooLarge:             "Content Too Large",
+	URITooLong:                  "URI Too Long",
+	UnsupportedMediaType:        "Unsupported Media Type",
+	RangeNotSatisfiable:         "Range Not Satisfiable",
+	ExpectationFailed:           "Expectation Failed",
+	MisdirectedRequest:          "Misdirected Request",
+	UnprocessableContent:        "Unprocessable Content",
+	Locked:                      "Locked",
+	FailedDependency:            "Failed Dependency",
+	TooEarly:                    "Too Early",
+	UpgradeRequired:             "Upgrade Required",
+	PreconditionRequired:        "Precondition Required",
+	TooManyRequests:             "Too Many Requests",
+	RequestHeaderFieldsTooLarge: "Request Header Fields Too Large",
+	UnavailableForLegalReasons:  "Unavailable For Legal Reasons",
+
+	InternalServerError:           "Internal Server Error",
+	NotImplemented:                "Not Implemented",
+	BadGateway:                    "Bad Gateway",
+	ServiceUnavailable:            "Service Unavailable",
+	GatewayTimeout:                "Gateway Timeout",
+	HTTPVersionNotSupported:       "HTTP Version Not Supported",
+	VariantAlsoNegotiates:         "Variant Also Negotiates",
+	InsufficientStorage:           "Insufficient Storage",
+	LoopDetected:                  "Loop Detected",
+	NotExtended:                   "Not Extended",
+	NetworkAuthenticationRequired: "Network Authentication Required",
+}
+
+// StatusText returns the reason phrase of a registered status code, or an empty string for unknown codes
+func StatusText(statusCode StatusCode) string {
+	return reasonPhrases[statusCode]
+}
+
+// Valid reports whether the status code is in the 100 to 599 range of RFC 9110 section 15
+func (c StatusCode) Valid() bool {
+	return c >= 100 && c <= 599
+}
+
+// AllowsBody reports whether a final response with the status code may carry a body, see RFC 9110 section 6.4.1
+func (c StatusCode) AllowsBody() bool {
+	return c >= 200 && c != NoContent && c != NotModified
+}
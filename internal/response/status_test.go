@@ -0,0 +1,38 @@
+package response
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWriteStatusLine(t *testing.T) {
+	cases := map[StatusCode]string{
+		OK:                          "HTTP/1.1 200 OK\r\n",
+		NoContent:                   "HTTP/1.1 204 No Content\r\n",
+		NotModified:                 "HTTP/1.1 304 Not Modified\r\n",
+		ContentTooLarge:             "HTTP/1.1 413 Content Too Large\r\n",
+		RequestHeaderFieldsTooLarge: "HTTP/1.1 431 Request Header Fields Too Large\r\n",
+		GatewayTimeout:              "HTTP/1.1 504 Gateway Timeout\r\n",
+		// Test: Unregistered codes keep an empty reason phrase
+		599: "HTTP/1.1 599 \r\n",
+	}
+
+	for statusCode, expected := range cases {
+		buffer := bytes.Buffer{}
+		require.NoError(t, WriteStatusLine(&buffer, statusCode))
+		assert.Equal(t, expected, buffer.String())
+	}
+}
+
+func TestWriteStatusLineWithReason(t *testing.T) {
+	buffer := bytes.Buffer{}
+	require.NoError(t, WriteStatusLineWithReason(&buffer, 599, "Network Connect Timeout"))
+	assert.Equal(t, "HTTP/1.1 599 Network Connect Timeout\r\n", buffer.String())
+
+	require.Error(t, WriteStatusLineWithReason(&bytes.Buffer{}, 599, "Injected\r\nX-Evil: yes"))
+	require.Error(t, WriteStatusLine(&bytes.Buffer{}, 99))
+	require.Error(t, WriteStatusLine(&bytes.Buffer{}, 600))
+	require.Error(t, WriteStatusLine(&bytes.Buffer{}, 1000))
+}
@@ -0,0 +1,367 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type writerState int
+
+const (
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateTrailers
+	writerStateDone
+)
+
+var ErrIncompleteBody = errors.New("error: body shorter than its Content-Length")
+
+// Writer writes a single response, enforcing the status line, headers, body and trailers order
+type Writer struct {
+	// CloseConnection makes WriteHeaders ask the client to close the connection after this response
+	CloseConnection bool
+	// OmitBody drops every body byte, as required when answering HEAD requests
+	OmitBody bool
+	// HTTP10 adapts the response to an HTTP/1.0 client: a kept-alive connection is announced with
+	// Connection: keep-alive, and a chunked body is sent as is, delimited by closing the connection
+	HTTP10 bool
+	// AutoChunked makes WriteHeaders pick chunked coding for a body without Content-Length, which WriteBody
+	// then frames, so that the connection survives responses streamed without a known length
+	AutoChunked bool
+
+	writer        io.Writer
+	state         writerState
+	statusCode    StatusCode
+	chunked       bool
+	autoChunked   bool
+	unframed      bool
+	trailers      []string
+	contentLength int64
+	written       int64
+	incomplete    bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: w, contentLength: -1}
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the status line with a caller supplied reason phrase, e.g. for unregistered codes
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
+	if w.state != writerStateStatusLine {
+		return outOfOrder("status line", w.state)
+	}
+
+	if err := WriteStatusLineWithReason(w.writer, statusCode, reason); err != nil {
+		return err
+	}
+
+	w.statusCode = statusCode
+	w.state = writerStateHeaders
+	return nil
+}
+
+// WriteInformational sends an interim 1xx response with its fields, e.g. 103 Early Hints, ahead of the final one.
+// Nothing is sent to HTTP/1.0 clients, which do not expect them, see RFC 9110 section 15.2.
+func (w *Writer) WriteInformational(statusCode StatusCode, h *headers.Headers) error {
+	if w.state != writerStateStatusLine {
+		return outOfOrder("informational response", w.state)
+	}
+
+	// 101 hands the connection over to another protocol, which a plain interim response does not do
+	if statusCode < 100 || statusCode > 199 || statusCode == SwitchingProtocols {
+		return fmt.Errorf("error: not an informational status code: %d", statusCode)
+	}
+
+	if w.HTTP10 {
+		return nil
+	}
+
+	if err := WriteStatusLine(w.writer, statusCode); err != nil {
+		return err
+	}
+
+	return WriteHeaders(w.writer, h)
+}
+
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	if w.state != writerStateHeaders {
+		return outOfOrder("headers", w.state)
+	}
+
+	trailers, err := declaredTrailers(h)
+	if err != nil {
+		return err
+	}
+	w.trailers = trailers
+
+	w.chunked = h.ContainsToken("Transfer-Encoding", "chunked")
+	if _, hasLength := h.Get("Content-Length"); w.AutoChunked && !w.HTTP10 && !hasLength &&
+		len(h.Values("Transfer-Encoding")) == 0 && w.statusCode.AllowsBody() {
+		h = h.Clone()
+		h.Set("Transfer-Encoding", "chunked")
+		w.chunked = true
+		w.autoChunked = true
+	}
+	if w.chunked && w.HTTP10 {
+		// HTTP/1.0 clients do not know chunked coding, the end of the body is signalled by closing instead
+		h = h.Clone()
+		h.Del("Transfer-Encoding")
+		h.Del("Trailer")
+		w.unframed = true
+		w.CloseConnection = true
+	}
+
+	if h.ContainsToken("Connection", "close") {
+		w.CloseConnection = true
+	} else if w.CloseConnection {
+		h = h.Clone()
+		h.Set("Connection", "close")
+	} else if w.HTTP10 && !h.ContainsToken("Connection", "keep-alive") {
+		h = h.Clone()
+		h.Set("Connection", "keep-alive")
+	}
+	if contentLength, ok := h.Get("Content-Length"); ok && !w.chunked {
+		length, err := strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || length < 0 {
+			return fmt.Errorf("error: invalid content length: %s", contentLength)
+		}
+		w.contentLength = length
+	}
+
+	if err := WriteHeaders(w.writer, h); err != nil {
+		return err
+	}
+
+	w.state = writerStateBody
+	return nil
+}
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, outOfOrder("body", w.state)
+	}
+
+	if w.autoChunked {
+		return w.WriteChunkedBody(p)
+	}
+	if w.chunked {
+		return 0, errors.New("error: chunked responses must be written with WriteChunkedBody")
+	}
+
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, fmt.Errorf("error: body longer than its Content-Length of %d", w.contentLength)
+	}
+
+	n, err := w.write(p)
+	w.written += int64(n)
+
+	return n, err
+}
+
+// Write makes Writer an io.Writer, it is the same as WriteBody
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.state != writerStateBody {
+		return 0, outOfOrder("chunked body", w.state)
+	}
+
+	if !w.chunked {
+		return 0, errors.New("error: chunked body written without Transfer-Encoding: chunked")
+	}
+
+	// An empty chunk would mark the end of the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if w.unframed {
+		return w.write(p)
+	}
+
+	if _, err := w.write(fmt.Appendf(nil, "%x\r\n", len(p))); err != nil {
+		return 0, err
+	}
+
+	n, err := w.write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if _, err = w.write([]byte("\r\n")); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if w.state != writerStateBody || !w.chunked {
+		return 0, outOfOrder("last chunk", w.state)
+	}
+
+	if w.unframed {
+		w.state = writerStateTrailers
+		return 0, nil
+	}
+
+	n, err := w.write([]byte("0\r\n"))
+	if err != nil {
+		return n, err
+	}
+
+	w.state = writerStateTrailers
+	return n, nil
+}
+
+func (w *Writer) WriteTrailers(h *headers.Headers) error {
+	if w.state != writerStateTrailers {
+		return outOfOrder("trailers", w.state)
+	}
+
+	// Trailers cannot be sent without chunked coding, they are dropped
+	if w.unframed {
+		w.state = writerStateDone
+		return nil
+	}
+
+	for name, value := range h.All() {
+		if err := validateField(name, value); err != nil {
+			return err
+		}
+		if err := w.checkTrailer(name); err != nil {
+			return err
+		}
+	}
+
+	for name, value := range h.All() {
+		if _, err := w.write(fmt.Appendf(nil, "%s: %s\r\n", name, value)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.write([]byte("\r\n")); err != nil {
+		return err
+	}
+
+	w.state = writerStateDone
+	return nil
+}
+
+// checkTrailer refuses fields that cannot be trailers and, when a Trailer header was sent, undeclared ones
+func (w *Writer) checkTrailer(name string) error {
+	if !allowedInTrailers(name) {
+		return fmt.Errorf("error: %s cannot be sent as a trailer", name)
+	}
+
+	if len(w.trailers) > 0 && !slices.ContainsFunc(w.trailers, func(declared string) bool {
+		return strings.EqualFold(declared, name)
+	}) {
+		return fmt.Errorf("error: trailer %s is not declared in the Trailer header", name)
+	}
+
+	return nil
+}
+
+// declaredTrailers returns the fields announced by the Trailer header, refusing those that cannot be trailers
+func declaredTrailers(h *headers.Headers) ([]string, error) {
+	var trailers []string
+	for _, value := range h.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if !allowedInTrailers(name) {
+				return nil, fmt.Errorf("error: %s cannot be declared as a trailer", name)
+			}
+			trailers = append(trailers, name)
+		}
+	}
+
+	return trailers, nil
+}
+
+// Finish writes whatever the handler left out to complete the response: a 200 status line,
+// empty headers, the last chunk or the end of the trailers
+func (w *Writer) Finish() error {
+	if w.state == writerStateStatusLine {
+		if err := w.WriteStatusLine(OK); err != nil {
+			return err
+		}
+	}
+
+	if w.state == writerStateHeaders {
+		if err := w.WriteHeaders(GetDefaultHeaders(0)); err != nil {
+			return err
+		}
+	}
+
+	if w.state == writerStateBody && w.chunked {
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+	}
+
+	if w.state == writerStateTrailers {
+		return w.WriteTrailers(headers.New())
+	}
+
+	if w.state == writerStateBody {
+		w.state = writerStateDone
+		// A 304 may announce the Content-Length of the representation, no body follows it
+		if !w.OmitBody && w.statusCode.AllowsBody() && w.contentLength >= 0 && w.written < w.contentLength {
+			w.incomplete = true
+			return ErrIncompleteBody
+		}
+	}
+
+	return nil
+}
+
+// Started reports whether the status line has been written, after which the response can no longer be replaced
+func (w *Writer) Started() bool {
+	return w.state != writerStateStatusLine
+}
+
+// Chunked reports whether the body is sent with chunked coding, after which trailers can follow it
+func (w *Writer) Chunked() bool {
+	return w.chunked && !w.unframed
+}
+
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// KeepAlive reports whether the client can tell where the finished response ends, so that the connection can be reused
+func (w *Writer) KeepAlive() bool {
+	if w.CloseConnection || w.incomplete || w.state != writerStateDone {
+		return false
+	}
+
+	return w.chunked || w.contentLength >= 0 || w.OmitBody || !w.statusCode.AllowsBody()
+}
+
+// write sends body bytes to the connection unless the body has to be omitted
+func (w *Writer) write(p []byte) (int, error) {
+	if w.OmitBody {
+		return len(p), nil
+	}
+
+	return w.writer.Write(p)
+}
+
+func outOfOrder(part string, state writerState) error {
+	return fmt.Errorf("error: %s written out of order in state %d", part, state)
+}
@@ -0,0 +1,279 @@
+package response
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"testing"
+)
+
+func headersOf(pairs ...string) *headers.Headers {
+	h := headers.New()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+
+	return h
+}
+
+func TestWriterWritesInOrder(t *testing.T) {
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+
+	// Test: Nothing but the status line can come first
+	_, err := w.WriteBody([]byte("too early"))
+	require.Error(t, err)
+	require.Error(t, w.WriteHeaders(headers.New()))
+
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.Error(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Length", "5")))
+	n, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 5, n)
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", buffer.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterEnforcesContentLength(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Length", "5")))
+
+	_, err := w.WriteBody([]byte("hello world"))
+	require.Error(t, err)
+
+	_, err = w.WriteBody([]byte("hel"))
+	require.NoError(t, err)
+	require.ErrorIs(t, w.Finish(), ErrIncompleteBody)
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriterChunkedBodyWithTrailers(t *testing.T) {
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Transfer-Encoding", "chunked")))
+
+	_, err := w.WriteBody([]byte("not chunked"))
+	require.Error(t, err)
+
+	_, err = w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte{})
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world, this is a longer chunk"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headersOf("X-Content-Length", "35")))
+	require.NoError(t, w.Finish())
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"6\r\nhello \r\n"+
+		"1d\r\nworld, this is a longer chunk\r\n"+
+		"0\r\nX-Content-Length: 35\r\n\r\n", buffer.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterAutoChunked(t *testing.T) {
+	// Test: A body written without length is framed as chunks
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+	w.AutoChunked = true
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Type", "text/plain")))
+	_, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n", buffer.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: A known length, a body-less status or an HTTP/1.0 client keep the headers as they are
+	for _, tc := range []struct {
+		statusCode StatusCode
+		headers    *headers.Headers
+		http10     bool
+	}{
+		{OK, headersOf("Content-Length", "0"), false},
+		{NoContent, headers.New(), false},
+		{NotModified, headersOf("ETag", `"abc"`), false},
+		{OK, headersOf("Content-Type", "text/plain"), true},
+	} {
+		buffer.Reset()
+		w = NewWriter(&buffer)
+		w.AutoChunked = true
+		w.HTTP10 = tc.http10
+		require.NoError(t, w.WriteStatusLine(tc.statusCode))
+		require.NoError(t, w.WriteHeaders(tc.headers))
+		assert.NotContains(t, buffer.String(), "Transfer-Encoding")
+	}
+}
+
+func TestWriterDeclaredTrailers(t *testing.T) {
+	chunkedWriter := func(h *headers.Headers) *Writer {
+		w := NewWriter(&bytes.Buffer{})
+		require.NoError(t, w.WriteStatusLine(OK))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteChunkedBodyDone()
+		require.NoError(t, err)
+		return w
+	}
+
+	// Test: Declared trailers are sent, undeclared ones refused
+	w := chunkedWriter(GetChunkedHeaders("X-Content-SHA256", "X-Content-Length"))
+	require.Error(t, w.WriteTrailers(headersOf("X-Other", "1")))
+	require.NoError(t, w.WriteTrailers(headersOf("X-Content-SHA256", "abc", "x-content-length", "0")))
+
+	// Test: Framing and routing fields can neither be declared nor sent as trailers
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.Error(t, w.WriteHeaders(GetChunkedHeaders("Content-Length")))
+	w = chunkedWriter(GetChunkedHeaders())
+	require.Error(t, w.WriteTrailers(headersOf("Transfer-Encoding", "gzip")))
+}
+
+func TestWriterFinish(t *testing.T) {
+	// Test: An untouched writer sends an empty 200
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buffer.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, buffer.String(), "Content-Length: 0\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: A chunked body gets its last chunk and empty trailers
+	buffer = bytes.Buffer{}
+	w = NewWriter(&buffer)
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Transfer-Encoding", "chunked")))
+	_, err := w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nhi\r\n0\r\n\r\n", buffer.String())
+
+	// Test: A body without length can only end with the connection
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Type", "text/plain")))
+	_, err = w.WriteBody([]byte("until close"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: A 304 announcing the length of the representation is complete without a body
+	w = NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(NotModified))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Length", "1234")))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriterConnectionAndOmittedBody(t *testing.T) {
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+	w.CloseConnection = true
+	w.OmitBody = true
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Length", "5")))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+
+	assert.Contains(t, buffer.String(), "Connection: close\r\n")
+	assert.True(t, bytes.HasSuffix(buffer.Bytes(), []byte("\r\n\r\n")))
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriterHTTP10(t *testing.T) {
+	// Test: A kept-alive connection is announced
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+	w.HTTP10 = true
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Content-Length", "2")))
+	_, err := w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buffer.String(), "Connection: keep-alive\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: A chunked body is sent without framing and ends with the connection
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	w.HTTP10 = true
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Transfer-Encoding", "chunked")))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headersOf("Digest", "abc")))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nConnection: close\r\n\r\nhello", buffer.String())
+	assert.False(t, w.KeepAlive())
+}
+
+func TestWriterInformational(t *testing.T) {
+	// Test: Interim responses come before the final one and leave it to be written
+	buffer := bytes.Buffer{}
+	w := NewWriter(&buffer)
+	require.NoError(t, w.WriteInformational(Continue, nil))
+	require.NoError(t, w.WriteInformational(EarlyHints, headersOf("Link", "</style.css>; rel=preload; as=style")))
+	assert.False(t, w.Started())
+	require.NoError(t, w.WriteStatusLine(NoContent))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n"+
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\n"+
+		"HTTP/1.1 204 No Content\r\nContent-Type: text/plain\r\nContent-Length: 0\r\n\r\n", buffer.String())
+
+	// Test: Only 1xx codes other than 101 are accepted, and only before the final response
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	require.Error(t, w.WriteInformational(OK, nil))
+	require.Error(t, w.WriteInformational(SwitchingProtocols, nil))
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.Error(t, w.WriteInformational(Continue, nil))
+
+	// Test: HTTP/1.0 clients get none
+	buffer.Reset()
+	w = NewWriter(&buffer)
+	w.HTTP10 = true
+	require.NoError(t, w.WriteInformational(EarlyHints, headersOf("Link", "</style.css>; rel=preload")))
+	assert.Empty(t, buffer.String())
+}
+
+func TestWriteHeadersKeepsOrderAndRepeats(t *testing.T) {
+	buffer := bytes.Buffer{}
+	h := headersOf("Content-Length", "0", "Set-Cookie", "a=1", "X-Trace", "t", "Set-Cookie", "b=2")
+
+	require.NoError(t, WriteHeaders(&buffer, h))
+	assert.Equal(t, "Content-Length: 0\r\nSet-Cookie: a=1\r\nX-Trace: t\r\nSet-Cookie: b=2\r\n\r\n", buffer.String())
+}
+
+func TestWriteHeadersRejectsInjection(t *testing.T) {
+	for _, h := range []*headers.Headers{
+		headersOf("Location", "/next\r\nSet-Cookie: admin=1"),
+		headersOf("X-Test", "a\nb"),
+		headersOf("X-Test", "a\x00b"),
+		headersOf("Bad Name", "value"),
+		headersOf("X-Test:Injected", "value"),
+	} {
+		buffer := bytes.Buffer{}
+		require.Error(t, WriteHeaders(&buffer, h))
+		assert.Empty(t, buffer.String())
+	}
+
+	// Test: Trailers are checked as well
+	w := NewWriter(&bytes.Buffer{})
+	require.NoError(t, w.WriteStatusLine(OK))
+	require.NoError(t, w.WriteHeaders(headersOf("Transfer-Encoding", "chunked")))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(headersOf("X-Checksum", "abc\r\n\r\nbody")))
+}
@@ -0,0 +1,228 @@
+package router
+
+import (
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	literal segmentKind = iota
+	param
+	wildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to the handler registered for their method and path
+type Router struct {
+	routes []*route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for pattern, which is an optional method followed by a path, e.g. "GET /users/{id}".
+// A {name} segment matches a single path segment and a trailing *name segment matches the rest of the path,
+// both are available through request.PathValue. A pattern without a method matches every method.
+// Handle panics when the pattern is invalid or already registered.
+func (rt *Router) Handle(pattern string, handler server.Handler) {
+	newRoute, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	newRoute.handler = handler
+
+	for _, existing := range rt.routes {
+		if existing.method == newRoute.method && sameSegments(existing.segments, newRoute.segments) {
+			panic(fmt.Errorf("pattern %q conflicts with %q", pattern, existing.pattern))
+		}
+	}
+
+	rt.routes = append(rt.routes, newRoute)
+}
+
+// Serve satisfies server.Handler, answering 404 for unknown paths and 405 for known paths with the wrong method
+func (rt *Router) Serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	// Asterisk-form and authority-form targets have no path to route on
+	if req.URL.Path == "" {
+		return &server.HandlerError{
+			StatusCode: 404,
+			Message:    "Not Found\n",
+		}
+	}
+	pathSegments := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+
+	var best *route
+	var bestValues map[string]string
+	var allowed []string
+	for _, candidate := range rt.routes {
+		values, ok := candidate.match(pathSegments)
+		if !ok {
+			continue
+		}
+
+		if !candidate.allows(req.RequestLine.Method) {
+			allowed = append(allowed, candidate.methods()...)
+			continue
+		}
+
+		if best == nil || candidate.moreSpecificThan(best) {
+			best, bestValues = candidate, values
+		}
+	}
+
+	if best == nil && len(allowed) > 0 {
+		slices.Sort(allowed)
+		allow := headers.New()
+		allow.Set("Allow", strings.Join(slices.Compact(allowed), ", "))
+		return &server.HandlerError{
+			StatusCode: 405,
+			Message:    "Method Not Allowed\n",
+			Headers:    allow,
+		}
+	}
+
+	if best == nil {
+		return &server.HandlerError{
+			StatusCode: 404,
+			Message:    "Not Found\n",
+		}
+	}
+
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+
+	return best.handler(w, req)
+}
+
+func parsePattern(pattern string) (*route, error) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+
+	if method != "" && !slices.Contains(request.Methods, method) {
+		return nil, fmt.Errorf("invalid method in pattern %q", pattern)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("pattern %q must start with /", pattern)
+	}
+
+	parts := strings.Split(path[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	names := make(map[string]bool)
+	for i, part := range parts {
+		var s segment
+		switch {
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") && len(part) > 2:
+			s = segment{kind: param, value: part[1 : len(part)-1]}
+		case strings.HasPrefix(part, "*") && len(part) > 1:
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard must be the last segment in pattern %q", pattern)
+			}
+			s = segment{kind: wildcard, value: part[1:]}
+		case strings.ContainsAny(part, "{}*"):
+			return nil, fmt.Errorf("invalid segment %q in pattern %q", part, pattern)
+		default:
+			s = segment{kind: literal, value: part}
+		}
+
+		if s.kind != literal {
+			if names[s.value] {
+				return nil, fmt.Errorf("duplicate name %q in pattern %q", s.value, pattern)
+			}
+			names[s.value] = true
+		}
+		segments = append(segments, s)
+	}
+
+	return &route{method: method, pattern: pattern, segments: segments}, nil
+}
+
+func (r *route) match(pathSegments []string) (map[string]string, bool) {
+	values := make(map[string]string)
+	for i, s := range r.segments {
+		if s.kind == wildcard {
+			values[s.value] = strings.Join(pathSegments[i:], "/")
+			return values, true
+		}
+
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+
+		switch s.kind {
+		case literal:
+			if s.value != pathSegments[i] {
+				return nil, false
+			}
+		case param:
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			values[s.value] = pathSegments[i]
+		}
+	}
+
+	if len(pathSegments) != len(r.segments) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+// allows reports whether the route serves method, GET routes also serve HEAD
+func (r *route) allows(method string) bool {
+	return r.method == "" || r.method == method || (r.method == http.MethodGet && method == http.MethodHead)
+}
+
+func (r *route) methods() []string {
+	if r.method == http.MethodGet {
+		return []string{http.MethodGet, http.MethodHead}
+	}
+
+	return []string{r.method}
+}
+
+// moreSpecificThan prefers literal segments over parameters and parameters over wildcards, from left to right
+func (r *route) moreSpecificThan(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+
+	if len(r.segments) != len(other.segments) {
+		return len(r.segments) > len(other.segments)
+	}
+
+	// A route bound to a method beats one accepting any method
+	return r.method != "" && other.method == ""
+}
+
+func sameSegments(a, b []segment) bool {
+	return slices.EqualFunc(a, b, func(x, y segment) bool {
+		return x.kind == y.kind && (x.kind != literal || x.value == y.value)
+	})
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"strings"
+	"testing"
+)
+
+func newRequest(t *testing.T, method, target string) *request.Request {
+	r, err := request.FromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+
+	return r
+}
+
+func named(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if err := w.WriteStatusLine(response.OK); err != nil {
+			panic(err)
+		}
+		if err := w.WriteHeaders(response.GetDefaultHeaders(len(name))); err != nil {
+			panic(err)
+		}
+		if _, err := w.WriteBody([]byte(name)); err != nil {
+			panic(err)
+		}
+		return nil
+	}
+}
+
+func serve(t *testing.T, rt *Router, method, target string) (string, *request.Request, *server.HandlerError) {
+	req := newRequest(t, method, target)
+	buffer := bytes.Buffer{}
+	handlerError := rt.Serve(response.NewWriter(&buffer), req)
+	_, body, _ := strings.Cut(buffer.String(), "\r\n\r\n")
+
+	return body, req, handlerError
+}
+
+func TestRouterMatchesParams(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("user"))
+	rt.Handle("GET /users/{id}/posts/{post}", named("post"))
+
+	body, req, handlerError := serve(t, rt, "GET", "/users/42")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "user", body)
+	assert.Equal(t, "42", req.PathValue("id"))
+
+	body, req, handlerError = serve(t, rt, "GET", "/users/42/posts/7?sort=asc")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "post", body)
+	assert.Equal(t, "42", req.PathValue("id"))
+	assert.Equal(t, "7", req.PathValue("post"))
+}
+
+func TestRouterMatchesWildcard(t *testing.T) {
+	rt := New()
+	rt.Handle("/static/*path", named("static"))
+
+	body, req, handlerError := serve(t, rt, "GET", "/static/css/site.css")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "static", body)
+	assert.Equal(t, "css/site.css", req.PathValue("path"))
+
+	// Test: A pattern without a method accepts any method
+	body, _, handlerError = serve(t, rt, "DELETE", "/static/a")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "static", body)
+}
+
+func TestRouterPrefersMostSpecificRoute(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /files/*path", named("wildcard"))
+	rt.Handle("GET /files/{name}", named("param"))
+	rt.Handle("GET /files/readme", named("literal"))
+
+	body, _, _ := serve(t, rt, "GET", "/files/readme")
+	assert.Equal(t, "literal", body)
+
+	body, _, _ = serve(t, rt, "GET", "/files/notes")
+	assert.Equal(t, "param", body)
+
+	body, _, _ = serve(t, rt, "GET", "/files/a/b")
+	assert.Equal(t, "wildcard", body)
+}
+
+func TestRouterNotFound(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("user"))
+
+	_, _, handlerError := serve(t, rt, "GET", "/users")
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 404, handlerError.StatusCode)
+
+	_, _, handlerError = serve(t, rt, "GET", "/accounts/42")
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 404, handlerError.StatusCode)
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("get"))
+	rt.Handle("DELETE /users/{id}", named("delete"))
+
+	_, _, handlerError := serve(t, rt, "POST", "/users/42")
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 405, handlerError.StatusCode)
+	assert.Equal(t, []string{"DELETE, GET, HEAD"}, handlerError.Headers.Values("Allow"))
+
+	// Test: GET routes answer HEAD requests
+	body, _, handlerError := serve(t, rt, "HEAD", "/users/42")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "get", body)
+}
+
+func TestRouterInvalidPatternsPanic(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("user"))
+
+	assert.Panics(t, func() { rt.Handle("GET /users/{name}", named("duplicate")) })
+	assert.Panics(t, func() { rt.Handle("users", named("relative")) })
+	assert.Panics(t, func() { rt.Handle("FETCH /users", named("method")) })
+	assert.Panics(t, func() { rt.Handle("/static/*path/more", named("wildcard")) })
+	assert.Panics(t, func() { rt.Handle("/a/{x}/{x}", named("names")) })
+}
+
+func TestRouterMatchesNormalisedPath(t *testing.T) {
+	rt := New()
+	rt.Handle("GET /users/{id}", named("user"))
+
+	body, req, handlerError := serve(t, rt, "GET", "/static/../users/jane%20doe")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "user", body)
+	assert.Equal(t, "jane doe", req.PathValue("id"))
+
+	// Test: An encoded slash stays within its segment
+	body, req, handlerError = serve(t, rt, "GET", "/users/a%2Fb")
+	require.Nil(t, handlerError)
+	assert.Equal(t, "user", body)
+	assert.Equal(t, "a%2Fb", req.PathValue("id"))
+
+	// Test: Server-wide OPTIONS requests match no route
+	_, _, handlerError = serve(t, rt, "OPTIONS", "*")
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 404, handlerError.StatusCode)
+}
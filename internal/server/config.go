@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"io"
+	"time"
+)
+
+// Config holds the timeouts and limits enforced by the server, a zero value disables the corresponding check
+type Config struct {
+	// ReadHeaderTimeout bounds reading the request line and headers, answered with 408 when exceeded
+	ReadHeaderTimeout time.Duration
+	// ReadBodyTimeout bounds reading the body once the headers are in, answered with 408 when exceeded
+	ReadBodyTimeout time.Duration
+	// WriteTimeout bounds writing the response
+	WriteTimeout time.Duration
+	// IdleTimeout bounds waiting for the next request on a kept-alive connection
+	IdleTimeout time.Duration
+	// MaxRequestLineBytes caps the size of the request line, answered with 414 when exceeded
+	MaxRequestLineBytes int
+	// MaxHeaderBytes caps the size of the request line and headers, answered with 431 when exceeded
+	MaxHeaderBytes int
+	// MaxHeaderCount caps the number of header fields, answered with 431 when exceeded
+	MaxHeaderCount int
+	// MaxBodyBytes caps the size of the body, answered with 413 when exceeded
+	MaxBodyBytes int64
+	// MaxConnections caps the number of open connections, new ones are answered with 503 when reached
+	MaxConnections int
+	// ObsFold decides whether folded header lines are answered with 400, the default, or joined with a space
+	ObsFold headers.ObsFoldPolicy
+	// StrictParsing rejects requests that other parsers could read differently, see request.Options
+	StrictParsing bool
+}
+
+func (c Config) requestOptions() request.Options {
+	return request.Options{
+		ObsFold:             c.ObsFold,
+		Strict:              c.StrictParsing,
+		MaxRequestLineBytes: orNoLimit(c.MaxRequestLineBytes),
+		MaxHeaderBytes:      orNoLimit(c.MaxHeaderBytes),
+		MaxHeaderCount:      orNoLimit(c.MaxHeaderCount),
+		MaxBodyBytes:        orNoLimit(c.MaxBodyBytes),
+	}
+}
+
+// orNoLimit maps a disabled limit to the negative value the parser takes for no limit, zero meaning its default there
+func orNoLimit[T int | int64](limit T) T {
+	if limit <= 0 {
+		return -1
+	}
+
+	return limit
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:   10 * time.Second,
+		ReadBodyTimeout:     time.Minute,
+		WriteTimeout:        time.Minute,
+		IdleTimeout:         2 * time.Minute,
+		MaxRequestLineBytes: request.DefaultMaxRequestLineBytes,
+		MaxHeaderBytes:      request.DefaultMaxHeaderBytes,
+		MaxHeaderCount:      request.DefaultMaxHeaderCount,
+		MaxBodyBytes:        request.DefaultMaxBodyBytes,
+		MaxConnections:      1024,
+	}
+}
+
+// Deadline turns a timeout into a connection deadline, the zero time meaning none
+func Deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
+
+// guardedBody remembers why reading the body failed, so that the server can answer for it
+// even when the handler ignores the error
+type guardedBody struct {
+	body io.ReadCloser
+	err  error
+}
+
+func (g *guardedBody) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+
+	n, err := g.body.Read(p)
+	if err != nil && err != io.EOF {
+		g.err = err
+	}
+
+	return n, err
+}
+
+func (g *guardedBody) Close() error {
+	return g.body.Close()
+}
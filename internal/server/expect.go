@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"io"
+	"strings"
+)
+
+// expectation reads the Expect header of an HTTP/1.1 request, reporting whether the client waits for 100 Continue
+// and whether the server can meet the expectation. HTTP/1.0 requests have it ignored, see RFC 9110 section 10.1.1.
+func expectation(req *request.Request) (expectsContinue bool, ok bool) {
+	values := req.Headers.Values("Expect")
+	if len(values) == 0 || !req.RequestLine.ProtoAtLeast(1, 1) {
+		return false, true
+	}
+
+	for _, value := range values {
+		if !strings.EqualFold(strings.TrimSpace(value), "100-continue") {
+			return false, false
+		}
+	}
+
+	return true, true
+}
+
+// continueBody sends 100 Continue the first time the handler reads the body, the client holding it back until then.
+// A handler answering without reading it never triggers the 100, and the connection is closed after the response
+// as the client may or may not send the body.
+type continueBody struct {
+	body            io.ReadCloser
+	w               *response.Writer
+	closeConnection bool
+	sent            bool
+}
+
+// newContinueBody wraps body, making w close the connection until 100 Continue is sent
+func newContinueBody(body io.ReadCloser, w *response.Writer) *continueBody {
+	c := &continueBody{body: body, w: w, closeConnection: w.CloseConnection}
+	w.CloseConnection = true
+
+	return c
+}
+
+func (c *continueBody) Read(p []byte) (int, error) {
+	// Once the final response has started, the client either sends the body anyway or gives up
+	if !c.sent && !c.w.Started() {
+		if err := c.w.WriteInformational(response.Continue, nil); err != nil {
+			return 0, fmt.Errorf("error: failed to send 100 Continue: %w", err)
+		}
+		c.sent = true
+		c.w.CloseConnection = c.closeConnection
+	}
+
+	return c.body.Read(p)
+}
+
+func (c *continueBody) Close() error {
+	return c.body.Close()
+}
@@ -1,61 +1,97 @@
 package server
 
 import (
+	"github.com/valivishy/httpfromtcp/internal/headers"
 	"github.com/valivishy/httpfromtcp/internal/request"
 	"github.com/valivishy/httpfromtcp/internal/response"
-	"io"
 )
 
 type HandlerError struct {
 	StatusCode int
 	Message    string
+	Headers    *headers.Headers
+	// Reason overrides the reason phrase, which defaults to the registered one for StatusCode
+	Reason string
 }
 
-type Handler func(w io.Writer, req *request.Request) *HandlerError
+// Handler writes the response to req through w. A returned HandlerError is sent to the client
+// unless the handler already started writing its own response.
+type Handler func(w *response.Writer, req *request.Request) *HandlerError
 
-func WriteHandlerError(w io.Writer, handlerError HandlerError) error {
-	var statusCode response.StatusCode
-	switch handlerError.StatusCode {
-	case 400:
-		statusCode = response.BadRequest
-	case 500:
-		statusCode = response.InternalServerError
-	default:
-		statusCode = response.BadRequest
+// ErrorReporter is told about the errors of the handlers made by HandlerOf, a *testing.T being one
+type ErrorReporter interface {
+	Errorf(format string, args ...any)
+}
+
+// HandlerOf adapts handle into a Handler, reporting its error to reporter and answering 500 for it.
+// Handlers run on the server's goroutines, where a test cannot be stopped, so tests write theirs with it.
+func HandlerOf(reporter ErrorReporter, handle func(w *response.Writer, req *request.Request) error) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		if err := handle(w, req); err != nil {
+			reporter.Errorf("handler failed: %v", err)
+			return &HandlerError{StatusCode: 500, Message: err.Error()}
+		}
+		return nil
 	}
+}
 
-	if err := response.WriteStatusLine(w, statusCode); err != nil {
-		return err
+func WriteHandlerError(w *response.Writer, handlerError HandlerError) error {
+	statusCode, reason := handlerError.statusCode(), handlerError.Reason
+	if reason == "" {
+		reason = response.StatusText(statusCode)
 	}
 
-	headers := response.GetDefaultHeaders(len(handlerError.Message))
-	if err := response.WriteHeaders(w, headers); err != nil {
+	if err := w.WriteStatusLineWithReason(statusCode, reason); err != nil {
 		return err
 	}
 
-	if _, err := w.Write([]byte(handlerError.Message)); err != nil {
-		return err
+	// 204 and 304 responses have neither body nor the fields describing one, see RFC 9110 section 8.6
+	if !statusCode.AllowsBody() {
+		return w.WriteHeaders(handlerError.headers(headers.New()))
 	}
 
-	return nil
+	return writeHeadersAndBody(w, handlerError.headers(response.GetDefaultHeaders(len(handlerError.Message))), []byte(handlerError.Message))
+}
+
+// headers returns responseHeaders with the ones set by the handler on top
+func (e HandlerError) headers(responseHeaders *headers.Headers) *headers.Headers {
+	for name := range e.Headers.All() {
+		responseHeaders.Del(name)
+	}
+	for name, value := range e.Headers.All() {
+		responseHeaders.Add(name, value)
+	}
+
+	return responseHeaders
+}
+
+// statusCode passes the handler's code through, falling back to 500 when it is not a valid final status code
+func (e HandlerError) statusCode() response.StatusCode {
+	statusCode := response.StatusCode(e.StatusCode)
+	if !statusCode.Valid() || statusCode < 200 {
+		return response.InternalServerError
+	}
+
+	return statusCode
 }
 
-func HandlerFunc(w io.Writer, req *request.Request) *HandlerError {
-	if req.RequestLine.RequestTarget == "/yourproblem" {
+func HandlerFunc(w *response.Writer, req *request.Request) *HandlerError {
+	if req.URL.Path == "/yourproblem" {
 		return &HandlerError{
 			StatusCode: 400,
 			Message:    "Your problem is not my problem",
 		}
 	}
 
-	if req.RequestLine.RequestTarget == "/myproblem" {
+	if req.URL.Path == "/myproblem" {
 		return &HandlerError{
 			StatusCode: 500,
 			Message:    "Woopsie, my bad\n",
 		}
 	}
 
-	if _, err := w.Write([]byte("All good, frfr\n")); err != nil {
+	body := []byte("All good, frfr\n")
+	if err := writeResponse(w, response.OK, response.GetDefaultHeaders(len(body)), body); err != nil {
 		panic(err)
 	}
 
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"log"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a Handler to add behaviour before and after it runs
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares into one, the first middleware being the outermost
+func Chain(middlewares ...Middleware) Middleware {
+	return func(handler Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+
+		return handler
+	}
+}
+
+// Logger logs the method, target, status code and duration of every request, using log.Default when logger is nil
+func Logger(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			start := time.Now()
+			handlerError := next(w, req)
+
+			statusCode := int(w.StatusCode())
+			switch {
+			case handlerError != nil && !w.Started():
+				statusCode = handlerError.StatusCode
+			case statusCode == 0:
+				// The server answers 200 for a handler that wrote nothing
+				statusCode = int(response.OK)
+			}
+			logger.Printf("%s %s %d %s", req.RequestLine.Method, req.RequestLine.RequestTarget, statusCode, time.Since(start))
+
+			return handlerError
+		}
+	}
+}
+
+// Recover turns a panicking handler into a 500 response instead of a dead connection
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) (handlerError *HandlerError) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					fmt.Printf("warning: handler panicked: %v\n", recovered)
+					handlerError = &HandlerError{
+						StatusCode: 500,
+						Message:    "Internal Server Error\n",
+					}
+				}
+			}()
+
+			return next(w, req)
+		}
+	}
+}
+
+// BasicAuth only lets through requests carrying one of the username and password pairs in credentials
+func BasicAuth(realm string, credentials map[string]string) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			username, password, ok := basicAuth(req)
+			if ok {
+				expected, known := credentials[username]
+				if known && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+					return next(w, req)
+				}
+			}
+
+			challenge := headers.New()
+			challenge.Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			return &HandlerError{
+				StatusCode: 401,
+				Message:    "Unauthorized\n",
+				Headers:    challenge,
+			}
+		}
+	}
+}
+
+func basicAuth(req *request.Request) (string, string, bool) {
+	authorization, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", "", false
+	}
+
+	scheme, encoded, found := strings.Cut(authorization, " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
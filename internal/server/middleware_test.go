@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"log"
+	"strings"
+	"testing"
+)
+
+func newRequest(t *testing.T, rawHeaders string) *request.Request {
+	r, err := request.FromReader(strings.NewReader("GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n" + rawHeaders + "\r\n"))
+	require.NoError(t, err)
+
+	return r
+}
+
+func tagging(tag string, calls *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			*calls = append(*calls, tag+" before")
+			handlerError := next(w, req)
+			*calls = append(*calls, tag+" after")
+			return handlerError
+		}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var calls []string
+	handler := Chain(tagging("outer", &calls), tagging("inner", &calls))(func(w *response.Writer, req *request.Request) *HandlerError {
+		calls = append(calls, "handler")
+		return nil
+	})
+
+	handlerError := handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, ""))
+	require.Nil(t, handlerError)
+	assert.Equal(t, []string{"outer before", "inner before", "handler", "inner after", "outer after"}, calls)
+}
+
+func TestRecover(t *testing.T) {
+	handler := Recover()(func(w *response.Writer, req *request.Request) *HandlerError {
+		panic("boom")
+	})
+
+	handlerError := handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, ""))
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 500, handlerError.StatusCode)
+}
+
+func TestLogger(t *testing.T) {
+	output := bytes.Buffer{}
+	handler := Logger(log.New(&output, "", 0))(HandlerFunc)
+
+	handlerError := handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, ""))
+	require.Nil(t, handlerError)
+	assert.True(t, strings.HasPrefix(output.String(), "GET /coffee 200 "))
+
+	// Test: A handler writing nothing is logged with the 200 the server sends for it
+	output.Reset()
+	silent := Logger(log.New(&output, "", 0))(func(w *response.Writer, req *request.Request) *HandlerError { return nil })
+	require.Nil(t, silent(response.NewWriter(&bytes.Buffer{}), newRequest(t, "")))
+	assert.True(t, strings.HasPrefix(output.String(), "GET /coffee 200 "), output.String())
+}
+
+func TestBasicAuth(t *testing.T) {
+	handler := BasicAuth("coffee", map[string]string{"barista": "latte"})(HandlerFunc)
+
+	// Test: Missing credentials
+	handlerError := handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, ""))
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 401, handlerError.StatusCode)
+	assert.Equal(t, []string{`Basic realm="coffee"`}, handlerError.Headers.Values("WWW-Authenticate"))
+
+	// Test: Wrong password
+	wrong := base64.StdEncoding.EncodeToString([]byte("barista:espresso"))
+	handlerError = handler(response.NewWriter(&bytes.Buffer{}), newRequest(t, "Authorization: Basic "+wrong+"\r\n"))
+	require.NotNil(t, handlerError)
+	assert.Equal(t, 401, handlerError.StatusCode)
+
+	// Test: Valid credentials
+	valid := base64.StdEncoding.EncodeToString([]byte("barista:latte"))
+	buffer := bytes.Buffer{}
+	handlerError = handler(response.NewWriter(&buffer), newRequest(t, "Authorization: Basic "+valid+"\r\n"))
+	require.Nil(t, handlerError)
+	assert.True(t, strings.HasSuffix(buffer.String(), "\r\n\r\nAll good, frfr\n"))
+}
@@ -1,22 +1,50 @@
 package server
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
 	"github.com/valivishy/httpfromtcp/internal/request"
 	"github.com/valivishy/httpfromtcp/internal/response"
+	"io"
 	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// Unread bodies up to this size are discarded so the connection can be reused, bigger ones close it
+	maxDrainBytes = 256 << 10
+
+	// Unread request bytes are discarded for this long after a rejection, so the client gets the response instead of a reset
+	lingerTimeout = 500 * time.Millisecond
+	lingerBytes   = 256 << 10
+
+	shutdownPollInterval = 10 * time.Millisecond
+	acceptRetryDelay     = 10 * time.Millisecond
+)
+
+type connState int
+
+const (
+	connStateActive connState = iota
+	connStateIdle
+)
+
 type Server struct {
-	listener net.Listener
-	handler  Handler
-	open     bool
+	listener   net.Listener
+	handler    Handler
+	config     Config
+	inShutdown atomic.Bool
+	mu         sync.Mutex
+	conns      map[net.Conn]connState
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	server := &Server{handler: handler}
+func Serve(port int, handler Handler, config Config) (*Server, error) {
+	server := &Server{handler: handler, config: config, conns: make(map[net.Conn]connState)}
 
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
@@ -24,77 +52,401 @@ func Serve(port int, handler Handler) (*Server, error) {
 	}
 
 	server.listener = listener
-	server.open = true
 
 	go server.listen()
 
 	return server, nil
 }
 
-func (s *Server) Close() {
-	err := s.listener.Close()
-	if err != nil {
-		panic(err)
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server immediately, closing the listener and every connection
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+	err := s.closeListener()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		closeConn(conn)
 	}
 
-	s.listener = nil
-	s.open = false
+	return err
 }
 
-func (s *Server) listen() bool {
+// Shutdown stops accepting connections, closes idle ones and waits for the active ones to finish their current request.
+// When ctx expires first the remaining connections are closed and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	err := s.closeListener()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
 	for {
-		if !s.open {
-			return false
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			if closeErr := s.Close(); closeErr != nil {
+				fmt.Printf("warning: failed to close server: %v\n", closeErr)
+			}
+			return ctx.Err()
+		case <-ticker.C:
 		}
+	}
+}
+
+func (s *Server) closeListener() error {
+	if err := s.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
 
+	return nil
+}
+
+// closeIdleConns closes the connections waiting for a request and reports whether no connection is left
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == connStateIdle {
+			closeConn(conn)
+		}
+	}
+
+	return len(s.conns) == 0
+}
+
+func (s *Server) listen() {
+	for {
 		accept, err := s.listener.Accept()
 		if err != nil {
-			panic(err)
+			if s.inShutdown.Load() {
+				return
+			}
+			fmt.Printf("warning: failed to accept connection: %v\n", err)
+			time.Sleep(acceptRetryDelay)
+			continue
+		}
+
+		if s.atCapacity() {
+			go s.reject(accept, response.ServiceUnavailable)
+			continue
+		}
+
+		if !s.trackConn(accept, connStateIdle) {
+			closeConn(accept)
+			continue
 		}
 
 		go s.handle(accept)
 	}
 }
 
+// trackConn records the state of conn, refusing to do so once the server is shutting down and the connection is idle
+func (s *Server) trackConn(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state == connStateIdle && s.inShutdown.Load() {
+		return false
+	}
+
+	s.conns[conn] = state
+	return true
+}
+
+func (s *Server) atCapacity() bool {
+	if s.config.MaxConnections <= 0 {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns) >= s.config.MaxConnections
+}
+
+// reject answers with statusCode and closes the connection without reading any further
+func (s *Server) reject(conn net.Conn, statusCode response.StatusCode) {
+	defer lingeringClose(conn)
+
+	if err := conn.SetWriteDeadline(Deadline(s.config.WriteTimeout)); err != nil {
+		fmt.Printf("warning: failed to set write deadline: %v\n", err)
+		return
+	}
+
+	w := response.NewWriter(conn)
+	w.CloseConnection = true
+	handlerError := HandlerError{StatusCode: int(statusCode), Message: response.StatusText(statusCode) + "\n"}
+	if err := WriteHandlerError(w, handlerError); err != nil {
+		fmt.Printf("warning: failed to write to connection: %v\n", err)
+	}
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
 func (s *Server) handle(conn net.Conn) {
 	defer func(conn net.Conn) {
-		err := conn.Close()
-		if err != nil {
-			panic(err)
-		}
+		s.untrackConn(conn)
+		closeConn(conn)
 	}(conn)
 
-	if err := conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
-		fmt.Printf("warning: failed to set read deadline: %v\n", err)
+	reader := &connReader{conn: conn}
+	for {
+		if !s.trackConn(conn, connStateIdle) {
+			return
+		}
+
+		if !waitForRequest(conn, reader, s.config.IdleTimeout) {
+			return
+		}
+
+		s.trackConn(conn, connStateActive)
+		if !s.serveRequest(conn, reader) {
+			return
+		}
+	}
+}
+
+// lingeringClose stops writing and discards what the client is still sending before closing conn,
+// as closing with unread bytes makes the kernel reset the connection and drop the response
+func lingeringClose(conn net.Conn) {
+	defer closeConn(conn)
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	if err := tcpConn.CloseWrite(); err != nil {
 		return
 	}
-	parsedRequest, err := request.FromReader(conn)
-	fmt.Printf("Parsed request: %#v\n", parsedRequest)
+	if err := conn.SetReadDeadline(time.Now().Add(lingerTimeout)); err != nil {
+		return
+	}
+	_, _ = io.CopyN(io.Discard, conn, lingerBytes)
+}
+
+func closeConn(conn net.Conn) {
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Printf("warning: failed to close connection: %v\n", err)
+	}
+}
+
+// serveRequest reads and answers a single request, reporting whether the connection can be reused
+func (s *Server) serveRequest(conn net.Conn, reader *connReader) bool {
+	if err := conn.SetReadDeadline(Deadline(s.config.ReadHeaderTimeout)); err != nil {
+		fmt.Printf("warning: failed to set read deadline: %v\n", err)
+		return false
+	}
+	if err := conn.SetWriteDeadline(Deadline(s.config.WriteTimeout)); err != nil {
+		fmt.Printf("warning: failed to set write deadline: %v\n", err)
+		return false
+	}
+
+	parsedRequest, err := request.FromReaderWithOptions(reader, s.config.requestOptions())
 	if err != nil {
 		fmt.Printf("warning: failed to parse request: %v\n", err)
-		return
+		if statusCode, ok := readErrorStatus(err); ok {
+			s.reject(conn, statusCode)
+		}
+		return false
+	}
+	// HTTP/1.1 made Host mandatory, see RFC 9112 section 3.2
+	if _, ok := parsedRequest.Headers.Get("Host"); !ok && parsedRequest.RequestLine.ProtoAtLeast(1, 1) {
+		s.reject(conn, response.BadRequest)
+		return false
+	}
+
+	parsedRequest.RemoteAddr = conn.RemoteAddr().String()
+
+	expectsContinue, ok := expectation(parsedRequest)
+	if !ok {
+		s.reject(conn, response.ExpectationFailed)
+		return false
+	}
+
+	if err = conn.SetReadDeadline(Deadline(s.config.ReadBodyTimeout)); err != nil {
+		fmt.Printf("warning: failed to set read deadline: %v\n", err)
+		return false
+	}
+	body := &guardedBody{body: parsedRequest.Body}
+	parsedRequest.Body = body
+
+	w := response.NewWriter(conn)
+	w.CloseConnection = !wantsKeepAlive(parsedRequest) || s.inShutdown.Load()
+	w.OmitBody = parsedRequest.RequestLine.Method == http.MethodHead
+	w.HTTP10 = !parsedRequest.RequestLine.ProtoAtLeast(1, 1)
+	w.AutoChunked = true
+
+	var continued *continueBody
+	// Without a body there is nothing for the client to hold back
+	if expectsContinue && parsedRequest.HasBody() {
+		continued = newContinueBody(body, w)
+		parsedRequest.Body = continued
+	}
+
+	handlerError := s.handler(w, parsedRequest)
+	if statusCode, ok := readErrorStatus(body.err); ok && !w.Started() {
+		// Whatever the handler made of it, the body could not be read in full
+		handlerError = &HandlerError{StatusCode: int(statusCode), Message: response.StatusText(statusCode) + "\n"}
+		w.CloseConnection = true
 	}
 
-	buffer := bytes.Buffer{}
-	handlerError := s.handler(&buffer, parsedRequest)
 	if handlerError != nil {
-		if err = WriteHandlerError(conn, *handlerError); err != nil {
-			panic(err)
+		if w.Started() {
+			fmt.Printf("warning: handler failed after writing its response: %s\n", handlerError.Message)
+			return false
 		}
-		return
+		if err = WriteHandlerError(w, *handlerError); err != nil {
+			fmt.Printf("warning: failed to write to connection: %v\n", err)
+			return false
+		}
+	}
+
+	if err = w.Finish(); err != nil {
+		fmt.Printf("warning: failed to finish response: %v\n", err)
+		return false
+	}
+
+	// The client is still holding the body back, reading it would wait forever
+	if continued != nil && !continued.sent {
+		closeBody(parsedRequest)
+		return false
 	}
 
-	if err = response.WriteStatusLine(conn, response.OK); err != nil {
-		panic(err)
+	if !drainBody(parsedRequest) {
+		return false
 	}
+	reader.unread(parsedRequest.Leftover())
+
+	return w.KeepAlive()
+}
 
-	if err = response.WriteHeaders(conn, response.GetDefaultHeaders(buffer.Len())); err != nil {
-		panic(err)
+// wantsKeepAlive applies the persistence rules of RFC 9112 section 9.3: HTTP/1.1 connections stay open unless
+// the client asks to close them, HTTP/1.0 ones are closed unless the client asks to keep them alive.
+// An HTTP/1.0 request carrying Transfer-Encoding may be framed otherwise by an intermediary, so its connection is
+// always closed, see RFC 9112 section 6.1.
+func wantsKeepAlive(req *request.Request) bool {
+	if req.Headers.ContainsToken("Connection", "close") {
+		return false
+	}
+	if _, ok := req.Headers.Get("Transfer-Encoding"); ok && !req.RequestLine.ProtoAtLeast(1, 1) {
+		return false
 	}
 
-	_, err = conn.Write(buffer.Bytes())
+	return req.RequestLine.ProtoAtLeast(1, 1) || req.Headers.ContainsToken("Connection", "keep-alive")
+}
+
+// readErrorStatus maps the errors met while reading a request to the status code telling the client why
+func readErrorStatus(err error) (response.StatusCode, bool) {
+	var statusErr request.StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return 0, false
+	case errors.As(err, &statusErr):
+		return response.StatusCode(statusErr.StatusCode()), true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return response.RequestTimeout, true
+	}
+
+	return 0, false
+}
+
+// drainBody discards whatever the handler left unread, reporting whether the whole body was consumed
+func drainBody(req *request.Request) bool {
+	defer closeBody(req)
+
+	n, err := io.CopyN(io.Discard, req.Body, maxDrainBytes+1)
+	if err == io.EOF {
+		return true
+	}
 	if err != nil {
-		fmt.Printf("warning: failed to write to connection: %v\n", err)
+		fmt.Printf("warning: failed to drain request body: %v\n", err)
+		return false
+	}
+
+	return n <= maxDrainBytes
+}
+
+func closeBody(req *request.Request) {
+	if err := req.Body.Close(); err != nil {
+		fmt.Printf("warning: failed to close request body: %v\n", err)
+	}
+}
+
+// waitForRequest blocks until the client starts sending its next request or the idle timeout expires
+func waitForRequest(conn net.Conn, reader *connReader, idleTimeout time.Duration) bool {
+	if len(reader.pending) > 0 {
+		return true
+	}
+
+	if err := conn.SetReadDeadline(Deadline(idleTimeout)); err != nil {
+		fmt.Printf("warning: failed to set idle deadline: %v\n", err)
+		return false
+	}
+
+	buffer := make([]byte, 1)
+	n, err := conn.Read(buffer)
+	if n > 0 {
+		reader.unread(buffer[:n])
+	}
+
+	return n > 0 && err == nil
+}
+
+func writeResponse(w *response.Writer, statusCode response.StatusCode, h *headers.Headers, body []byte) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+
+	return writeHeadersAndBody(w, h, body)
+}
+
+func writeHeadersAndBody(w *response.Writer, h *headers.Headers, body []byte) error {
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	if _, err := w.WriteBody(body); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// connReader serves bytes pushed back after a request, e.g. a pipelined request, before reading from the connection
+type connReader struct {
+	conn    net.Conn
+	pending []byte
+}
+
+func (c *connReader) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	return c.conn.Read(p)
+}
+
+func (c *connReader) unread(data []byte) {
+	if len(data) == 0 {
 		return
 	}
+
+	c.pending = append(data[:len(data):len(data)], c.pending...)
 }
@@ -0,0 +1,568 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dial(t *testing.T, server *Server) net.Conn {
+	conn, err := net.Dial("tcp", server.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestKeepAlive(t *testing.T) {
+	server, err := Serve(0, HandlerFunc, DefaultConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	conn := dial(t, server)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	expected := "HTTP/1.1 200 OK\r\n"
+	buffer := make([]byte, 256)
+	n, err := conn.Read(buffer)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(buffer[:n]), expected))
+	assert.NotContains(t, string(buffer[:n]), "Connection: close")
+
+	// Test: The same connection serves a second request and closes when asked to
+	_, err = conn.Write([]byte("GET /yourproblem HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(rest), "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, string(rest), "Connection: close\r\n")
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	server, err := Serve(0, HandlerFunc, DefaultConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+	conn := dial(t, server)
+
+	_, err = conn.Write([]byte("POST /submit HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+		"GET /myproblem HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(responses), "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, 1, strings.Count(string(responses), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.Less(t, strings.Index(string(responses), "500"), strings.LastIndex(string(responses), "200 OK"))
+}
+
+func TestWriteHandlerErrorPassesStatusThrough(t *testing.T) {
+	cases := map[string]HandlerError{
+		"HTTP/1.1 404 Not Found\r\n":               {StatusCode: 404, Message: "nope"},
+		"HTTP/1.1 429 Too Many Requests\r\n":       {StatusCode: 429},
+		"HTTP/1.1 599 Network Connect Timeout\r\n": {StatusCode: 599, Reason: "Network Connect Timeout"},
+		"HTTP/1.1 500 Internal Server Error\r\n":   {StatusCode: 42},
+	}
+
+	for expected, handlerError := range cases {
+		buffer := bytes.Buffer{}
+		require.NoError(t, WriteHandlerError(response.NewWriter(&buffer), handlerError))
+		assert.True(t, strings.HasPrefix(buffer.String(), expected), buffer.String())
+		assert.True(t, strings.HasSuffix(buffer.String(), "\r\n\r\n"+handlerError.Message))
+	}
+
+	// Test: Codes past 599 and informational ones are no final status
+	for _, statusCode := range []int{700, 103} {
+		buffer := bytes.Buffer{}
+		require.NoError(t, WriteHandlerError(response.NewWriter(&buffer), HandlerError{StatusCode: statusCode}))
+		assert.True(t, strings.HasPrefix(buffer.String(), "HTTP/1.1 500 Internal Server Error\r\n"), buffer.String())
+	}
+
+	// Test: Body-less statuses get neither a body nor the fields describing one
+	buffer := bytes.Buffer{}
+	h := headers.New()
+	h.Set("ETag", "\"v1\"")
+	require.NoError(t, WriteHandlerError(response.NewWriter(&buffer), HandlerError{StatusCode: 204, Message: "ignored", Headers: h}))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nETag: \"v1\"\r\n\r\n", buffer.String())
+}
+
+func TestShutdownWaitsForActiveRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		<-release
+		return HandlerFunc(w, req)
+	}, DefaultConfig())
+	require.NoError(t, err)
+	conn := dial(t, server)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-shutdown)
+
+	// Test: The in-flight request is answered and the connection closed afterwards
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 200 OK\r\n"))
+
+	_, err = net.Dial("tcp", server.Addr().String())
+	require.Error(t, err)
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	server, err := Serve(0, HandlerFunc, DefaultConfig())
+	require.NoError(t, err)
+	conn := dial(t, server)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	buffer := make([]byte, 256)
+	_, err = conn.Read(buffer)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, server.Shutdown(ctx))
+
+	_, err = conn.Read(buffer)
+	require.Error(t, err)
+}
+
+func TestShutdownForceClosesOnContextExpiry(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		<-release
+		return nil
+	}, DefaultConfig())
+	require.NoError(t, err)
+	conn := dial(t, server)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = io.ReadAll(conn)
+	require.NoError(t, err)
+}
+
+func serveWithConfig(t *testing.T, handler Handler, config Config) *Server {
+	server, err := Serve(0, handler, config)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = server.Close() })
+
+	return server
+}
+
+func echoBody(w *response.Writer, req *request.Request) *HandlerError {
+	body, err := req.ReadBody(0)
+	if err != nil {
+		return &HandlerError{StatusCode: 400, Message: err.Error()}
+	}
+
+	return &HandlerError{StatusCode: 200, Message: string(body)}
+}
+
+func TestConfigReadHeaderTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadHeaderTimeout = 50 * time.Millisecond
+	conn := dial(t, serveWithConfig(t, HandlerFunc, config))
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: local"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 408 Request Timeout\r\n"))
+}
+
+func TestConfigReadBodyTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadBodyTimeout = 50 * time.Millisecond
+	conn := dial(t, serveWithConfig(t, echoBody, config))
+
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 408 Request Timeout\r\n"))
+}
+
+func TestConfigMaxHeaderBytes(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxHeaderBytes = 64
+	conn := dial(t, serveWithConfig(t, HandlerFunc, config))
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nCookie: " + strings.Repeat("a", 100) + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 431 Request Header Fields Too Large\r\n"))
+}
+
+func TestConfigMaxBodyBytes(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBodyBytes = 8
+	server := serveWithConfig(t, echoBody, config)
+
+	// Test: A declared length over the limit is refused upfront
+	conn := dial(t, server)
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: A chunked body is cut once it grows over the limit
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: A body within the limit goes through
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhi there"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(responses), "hi there"))
+}
+
+func TestConfigMaxConnections(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxConnections = 1
+	server := serveWithConfig(t, HandlerFunc, config)
+
+	first := dial(t, server)
+	_, err := first.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	_, err = first.Read(make([]byte, 256))
+	require.NoError(t, err)
+
+	second := dial(t, server)
+	responses, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 503 Service Unavailable\r\n"))
+}
+
+func TestBadRequestsAreAnswered(t *testing.T) {
+	server := serveWithConfig(t, HandlerFunc, DefaultConfig())
+
+	for _, tc := range []struct {
+		request    string
+		statusLine string
+	}{
+		{"GET /\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+		{"BREW /pot HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.1 501 Not Implemented\r\n"},
+		{"GET / HTTP/2.0\r\nHost: localhost\r\n\r\n", "HTTP/1.1 505 HTTP Version Not Supported\r\n"},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: abc\r\n\r\n", "HTTP/1.1 400 Bad Request\r\n"},
+	} {
+		conn := dial(t, server)
+		_, err := conn.Write([]byte(tc.request))
+		require.NoError(t, err)
+
+		responses, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(responses), tc.statusLine), "%q answered with %q", tc.request, responses)
+		assert.Contains(t, string(responses), "Connection: close\r\n")
+	}
+}
+
+func TestHTTP10ConnectionSemantics(t *testing.T) {
+	server := serveWithConfig(t, HandlerFunc, DefaultConfig())
+
+	// Test: HTTP/1.0 closes by default and does not need Host
+	conn := dial(t, server)
+	_, err := conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(responses), "Connection: close\r\n")
+
+	// Test: Connection: keep-alive keeps the connection open and is echoed back
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\nGET /myproblem HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	first, second, found := strings.Cut(string(responses), "All good, frfr\n")
+	require.True(t, found)
+	assert.Contains(t, first, "Connection: keep-alive\r\n")
+	assert.True(t, strings.HasPrefix(second, "HTTP/1.1 500 Internal Server Error\r\n"))
+
+	// Test: Transfer-Encoding closes the connection despite Connection: keep-alive, the pipelined request is not read
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("POST / HTTP/1.0\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET /myproblem HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(responses), "Connection: close\r\n")
+	assert.Equal(t, 1, strings.Count(string(responses), "HTTP/1.1 "))
+}
+
+func chunkedHeaders() *headers.Headers {
+	h := headers.New()
+	h.Set("Transfer-Encoding", "chunked")
+
+	return h
+}
+
+func TestHTTP10ChunkedResponseIsUnframed(t *testing.T) {
+	chunked := HandlerOf(t, func(w *response.Writer, req *request.Request) error {
+		if err := w.WriteStatusLine(response.OK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(chunkedHeaders()); err != nil {
+			return err
+		}
+		if _, err := w.WriteChunkedBody([]byte("hello ")); err != nil {
+			return err
+		}
+		_, err := w.WriteChunkedBody([]byte("world"))
+		return err
+	})
+	conn := dial(t, serveWithConfig(t, chunked, DefaultConfig()))
+
+	_, err := conn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(responses), "Transfer-Encoding")
+	assert.Contains(t, string(responses), "Connection: close\r\n")
+	assert.True(t, strings.HasSuffix(string(responses), "\r\n\r\nhello world"))
+}
+
+func TestHTTP11RequiresHost(t *testing.T) {
+	conn := dial(t, serveWithConfig(t, HandlerFunc, DefaultConfig()))
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestConfigStrictParsing(t *testing.T) {
+	config := DefaultConfig()
+	config.StrictParsing = true
+	conn := dial(t, serveWithConfig(t, echoBody, config))
+
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestConfigMaxRequestLineBytes(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxRequestLineBytes = 32
+	conn := dial(t, serveWithConfig(t, HandlerFunc, config))
+
+	_, err := conn.Write([]byte("GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 414 URI Too Long\r\n"))
+}
+
+func TestExpectContinue(t *testing.T) {
+	server := serveWithConfig(t, echoBody, DefaultConfig())
+
+	// Test: The client gets 100 Continue once the handler reads the body, and the connection stays open
+	conn := dial(t, server)
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	interim := make([]byte, len("HTTP/1.1 100 Continue\r\n\r\n"))
+	_, err = io.ReadFull(conn, interim)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\n", string(interim))
+
+	_, err = conn.Write([]byte("hello" + "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	first, second, found := strings.Cut(string(responses), "hello")
+	require.True(t, found)
+	assert.True(t, strings.HasPrefix(first, "HTTP/1.1 200 OK\r\n"))
+	assert.NotContains(t, first, "Connection: close")
+	assert.True(t, strings.HasPrefix(second, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Without a body no 100 is due and the pipelined request behind is answered
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nExpect: 100-continue\r\n\r\n" +
+		"GET / HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.NotContains(t, string(responses), "100 Continue")
+	assert.Equal(t, 2, strings.Count(string(responses), "HTTP/1.1 200 OK\r\n"), string(responses))
+
+	// Test: Unknown expectations are refused
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: something-else\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 417 Expectation Failed\r\n"))
+
+	// Test: HTTP/1.0 requests have Expect ignored
+	conn = dial(t, server)
+	_, err = conn.Write([]byte("POST / HTTP/1.0\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\nhello"))
+	require.NoError(t, err)
+	responses, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(responses), "hello"))
+}
+
+func TestExpectContinueRejectedWithoutReadingBody(t *testing.T) {
+	tooLarge := func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{StatusCode: int(response.ContentTooLarge), Message: "too large\n"}
+	}
+	conn := dial(t, serveWithConfig(t, tooLarge, DefaultConfig()))
+
+	// Test: No 100 Continue is sent and the connection is closed, the client never sending the body
+	_, err := conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5000000\r\nExpect: 100-continue\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses), "HTTP/1.1 413 Content Too Large\r\n"))
+	assert.Contains(t, string(responses), "Connection: close\r\n")
+	assert.NotContains(t, string(responses), "100 Continue")
+}
+
+func TestEarlyHints(t *testing.T) {
+	hints := HandlerOf(t, func(w *response.Writer, req *request.Request) error {
+		link := headers.New()
+		link.Set("Link", "</style.css>; rel=preload; as=style")
+		return w.WriteInformational(response.EarlyHints, link)
+	})
+	conn := dial(t, serveWithConfig(t, hints, DefaultConfig()))
+
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(responses),
+		"HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload; as=style\r\n\r\nHTTP/1.1 200 OK\r\n"))
+}
+
+func TestBinaryBodyEchoed(t *testing.T) {
+	echo := HandlerOf(t, func(w *response.Writer, req *request.Request) error {
+		body, err := req.ReadBody(0)
+		if err != nil {
+			return err
+		}
+		h := headers.New()
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		if err = w.WriteStatusLine(response.OK); err != nil {
+			return err
+		}
+		if err = w.WriteHeaders(h); err != nil {
+			return err
+		}
+		_, err = w.WriteBody(body)
+		return err
+	})
+	conn := dial(t, serveWithConfig(t, echo, DefaultConfig()))
+
+	// Test: NUL bytes and CRLFs in the body neither end it early nor leak into the next request
+	body := append(make([]byte, 1024), "\r\n\r\n\x00\xff"...)
+	_, err := conn.Write(append([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"), body...))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	first, second, found := strings.Cut(string(responses), "\r\n\r\n")
+	require.True(t, found)
+	assert.True(t, strings.HasPrefix(first, "HTTP/1.1 200 OK\r\n"))
+	assert.Equal(t, string(body), second[:len(body)])
+	assert.True(t, strings.HasPrefix(second[len(body):], "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestStreamedResponseIsChunkedWithTrailers(t *testing.T) {
+	stream := HandlerOf(t, func(w *response.Writer, req *request.Request) error {
+		h := headers.New()
+		h.Set("Content-Type", "text/plain")
+		h.Set("Trailer", "X-Content-SHA256, X-Content-Length")
+		if err := w.WriteStatusLine(response.OK); err != nil {
+			return err
+		}
+		if err := w.WriteHeaders(h); err != nil {
+			return err
+		}
+
+		hash := sha256.New()
+		n, err := io.Copy(io.MultiWriter(w, hash), strings.NewReader("streamed without a length"))
+		if err != nil {
+			return err
+		}
+		if _, err = w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+
+		trailers := headers.New()
+		trailers.Set("X-Content-SHA256", hex.EncodeToString(hash.Sum(nil)))
+		trailers.Set("X-Content-Length", strconv.FormatInt(n, 10))
+		return w.WriteTrailers(trailers)
+	})
+	conn := dial(t, serveWithConfig(t, stream, DefaultConfig()))
+
+	// Test: The connection survives the streamed response
+	_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	responses, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(responses), "HTTP/1.1 200 OK\r\n"))
+
+	first, _, _ := strings.Cut(string(responses)[1:], "HTTP/1.1 200 OK")
+	sum := sha256.Sum256([]byte("streamed without a length"))
+	assert.Contains(t, first, "Transfer-Encoding: chunked\r\n")
+	assert.NotContains(t, first, "\r\nContent-Length")
+	assert.True(t, strings.HasSuffix(first, "\r\n\r\n19\r\nstreamed without a length\r\n0\r\n"+
+		"X-Content-SHA256: "+hex.EncodeToString(sum[:])+"\r\nX-Content-Length: 25\r\n\r\n"))
+}
@@ -0,0 +1,97 @@
+package static
+
+import (
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type precondition int
+
+const (
+	preconditionPassed precondition = iota
+	preconditionFailed
+	notModified
+)
+
+// checkPreconditions evaluates the conditional fields in the order of RFC 9110 section 13.2.2.
+// Only GET and HEAD are served, so a matching If-None-Match always means 304.
+func checkPreconditions(h *headers.Headers, etag string, modTime time.Time) precondition {
+	if ifMatch, ok := h.Get("If-Match"); ok {
+		if !matchETag(ifMatch, etag, false) {
+			return preconditionFailed
+		}
+	} else if since, ok := parseDate(h, "If-Unmodified-Since"); ok && modTime.After(since) {
+		return preconditionFailed
+	}
+
+	if ifNoneMatch, ok := h.Get("If-None-Match"); ok {
+		if matchETag(ifNoneMatch, etag, true) {
+			return notModified
+		}
+	} else if since, ok := parseDate(h, "If-Modified-Since"); ok && !modTime.After(since) {
+		return notModified
+	}
+
+	return preconditionPassed
+}
+
+// rangeApplies reports whether the Range field is to be honoured, which If-Range only allows when the
+// representation is still the one the client has part of, see RFC 9110 section 13.1.5
+func rangeApplies(h *headers.Headers, etag string, modTime time.Time) bool {
+	ifRange, ok := h.Get("If-Range")
+	if !ok {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		return matchETag(ifRange, etag, false)
+	}
+
+	date, err := http.ParseTime(ifRange)
+	return err == nil && date.Equal(modTime)
+}
+
+// matchETag reports whether the comma separated list holds etag or is "*". A weak comparison ignores the W/
+// prefix of the listed tags, a strong one never matches them, see RFC 9110 section 8.8.3.2.
+func matchETag(list, etag string, weak bool) bool {
+	for list = trimList(list); list != ""; list = trimList(list) {
+		if list[0] == '*' {
+			return true
+		}
+
+		candidate := list
+		isWeak := strings.HasPrefix(candidate, "W/")
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if len(candidate) < 2 || candidate[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(candidate[1:], '"')
+		if end < 0 {
+			return false
+		}
+		candidate, list = candidate[:end+2], candidate[end+2:]
+
+		if candidate == etag && (weak || !isWeak) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func trimList(list string) string {
+	return strings.TrimLeft(list, " \t,")
+}
+
+// parseDate parses the HTTP-date of the named field, an invalid date being ignored like a missing one
+func parseDate(h *headers.Headers, name string) (time.Time, bool) {
+	value, ok := h.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	date, err := http.ParseTime(value)
+	return date, err == nil
+}
@@ -0,0 +1,197 @@
+package static
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"html/template"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is a file or directory of a listing
+type entry struct {
+	Name    string    `json:"name"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type listing struct {
+	Path    string  `json:"path"`
+	Sort    string  `json:"sort"`
+	Order   string  `json:"order"`
+	Entries []entry `json:"entries"`
+}
+
+var sortKeys = map[string]func(a, b entry) int{
+	"name":  func(a, b entry) int { return strings.Compare(a.Name, b.Name) },
+	"size":  func(a, b entry) int { return cmp.Compare(a.Size, b.Size) },
+	"mtime": func(a, b entry) int { return a.ModTime.Compare(b.ModTime) },
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	// The ./ keeps a name like a:b from being read as a URI scheme
+	"href": func(e entry) string {
+		if e.Dir {
+			return "./" + url.PathEscape(e.Name) + "/"
+		}
+		return "./" + url.PathEscape(e.Name)
+	},
+	"sortLink": func(l listing, key string) string {
+		order := "asc"
+		if l.Sort == key && l.Order == "asc" {
+			order = "desc"
+		}
+		return "?sort=" + key + "&order=" + order
+	},
+	"date": func(t time.Time) string { return t.UTC().Format(time.DateTime) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th><a href="{{sortLink . "name"}}">Name</a></th><th><a href="{{sortLink . "size"}}">Size</a></th><th><a href="{{sortLink . "mtime"}}">Modified</a></th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{href .}}">{{.Name}}{{if .Dir}}/{{end}}</a></td><td>{{if not .Dir}}{{.Size}}{{end}}</td><td>{{date .ModTime}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveListing answers with the entries of dir, rendered as JSON when the client prefers it to HTML
+func serveListing(w *response.Writer, req *request.Request, dir *os.File, showHidden bool) *server.HandlerError {
+	query := req.URL.Query()
+	l := listing{Path: req.URL.Path, Sort: cmp.Or(query.Get("sort"), "name"), Order: cmp.Or(query.Get("order"), "asc")}
+	compare, ok := sortKeys[l.Sort]
+	if !ok || (l.Order != "asc" && l.Order != "desc") {
+		return &server.HandlerError{StatusCode: int(response.BadRequest), Message: "Bad Request\n"}
+	}
+
+	entries, err := readEntries(dir, showHidden)
+	if err != nil {
+		return internalError(err)
+	}
+	// Directories come first, ties are broken by name so that the order is stable
+	slices.SortFunc(entries, func(a, b entry) int {
+		if a.Dir != b.Dir {
+			if a.Dir {
+				return -1
+			}
+			return 1
+		}
+		result := cmp.Or(compare(a, b), strings.Compare(a.Name, b.Name))
+		if l.Order == "desc" {
+			return -result
+		}
+		return result
+	})
+	l.Entries = entries
+
+	var body bytes.Buffer
+	contentType := "text/html; charset=utf-8"
+	accept, _ := req.Headers.Get("Accept")
+	if prefersJSON(accept) {
+		contentType = "application/json"
+		err = json.NewEncoder(&body).Encode(l)
+	} else {
+		err = listingTemplate.Execute(&body, l)
+	}
+	if err != nil {
+		return internalError(err)
+	}
+
+	h := headers.New()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(body.Len()))
+	h.Set("Vary", "Accept")
+	if err = writeHead(w, response.OK, h); err == nil {
+		_, err = w.WriteBody(body.Bytes())
+	}
+	if err != nil {
+		return internalError(err)
+	}
+
+	return nil
+}
+
+func readEntries(dir *os.File, showHidden bool) ([]entry, error) {
+	dirEntries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !showHidden && strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			// The entry was removed since the directory was read
+			continue
+		}
+		e := entry{Name: dirEntry.Name(), Dir: info.IsDir(), ModTime: info.ModTime().UTC()}
+		if !e.Dir {
+			e.Size = info.Size()
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// prefersJSON reports whether the Accept field ranks application/json above text/html, the most specific
+// media range deciding the quality of each, see RFC 9110 section 12.5.1
+func prefersJSON(accept string) bool {
+	return mediaQuality(accept, "application", "json") > mediaQuality(accept, "text", "html")
+}
+
+func mediaQuality(accept, mainType, subType string) float64 {
+	quality, specificity := 0.0, -1
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+
+	for _, element := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(element, ";")
+		rangeType, rangeSubType, _ := strings.Cut(strings.ToLower(strings.TrimSpace(mediaRange)), "/")
+
+		var rangeSpecificity int
+		switch {
+		case rangeType == mainType && rangeSubType == subType:
+			rangeSpecificity = 2
+		case rangeType == mainType && rangeSubType == "*":
+			rangeSpecificity = 1
+		case rangeType == "*" && rangeSubType == "*":
+			rangeSpecificity = 0
+		default:
+			continue
+		}
+		if rangeSpecificity <= specificity {
+			continue
+		}
+
+		specificity, quality = rangeSpecificity, 1
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+	}
+
+	return quality
+}
@@ -0,0 +1,160 @@
+package static
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxRanges caps the ranges of a request, more are ignored and the whole file sent
+const maxRanges = 100
+
+var (
+	errInvalidRange  = errors.New("error: invalid range")
+	errUnsatisfiable = errors.New("error: no satisfiable range")
+)
+
+// byteRange is a part of a file of length bytes starting at start
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" +
+		strconv.FormatInt(size, 10)
+}
+
+// parseRange parses a Range field for a file of size bytes, see RFC 9110 section 14.2.
+// Ranges starting past the end are dropped and errUnsatisfiable returned when none is left. Ranges adding
+// up to more than the file, usually overlapping ones, are refused with errInvalidRange.
+func parseRange(value string, size int64) ([]byteRange, error) {
+	unit, specs, ok := strings.Cut(value, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	var total int64
+	count := 0
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if count++; count > maxRanges {
+			return nil, errInvalidRange
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+
+		var r byteRange
+		if first == "" {
+			// A suffix range is the last bytes of the file
+			length, err := parseNumber(last)
+			if err != nil {
+				return nil, err
+			}
+			if length == 0 || size == 0 {
+				continue
+			}
+			r = byteRange{start: max(size-length, 0), length: min(length, size)}
+		} else {
+			start, err := parseNumber(first)
+			if err != nil {
+				return nil, err
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = parseNumber(last); err != nil {
+					return nil, err
+				}
+				if end < start {
+					return nil, errInvalidRange
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: min(end, size-1) - start + 1}
+		}
+
+		if total += r.length; total > size {
+			return nil, errInvalidRange
+		}
+		ranges = append(ranges, r)
+	}
+
+	if count == 0 {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+
+	return ranges, nil
+}
+
+func parseNumber(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, errInvalidRange
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errInvalidRange
+	}
+
+	return n, nil
+}
+
+// rangeParts is a multipart/byteranges body, see RFC 9110 section 14.6.
+// Its part headers are built upfront so that its Content-Length is known before writing it.
+type rangeParts struct {
+	boundary string
+	ranges   []byteRange
+	heads    []string
+	closing  string
+}
+
+func newRangeParts(ranges []byteRange, contentType string, size int64) *rangeParts {
+	parts := &rangeParts{boundary: rand.Text(), ranges: ranges}
+	for i, r := range ranges {
+		head := "--" + parts.boundary + "\r\nContent-Type: " + contentType + "\r\nContent-Range: " + r.contentRange(size) + "\r\n\r\n"
+		if i > 0 {
+			head = "\r\n" + head
+		}
+		parts.heads = append(parts.heads, head)
+	}
+	parts.closing = "\r\n--" + parts.boundary + "--\r\n"
+
+	return parts
+}
+
+func (p *rangeParts) length() int64 {
+	length := int64(len(p.closing))
+	for i, r := range p.ranges {
+		length += int64(len(p.heads[i])) + r.length
+	}
+
+	return length
+}
+
+func (p *rangeParts) write(w io.Writer, file *os.File) error {
+	for i, r := range p.ranges {
+		if _, err := io.WriteString(w, p.heads[i]); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(file, r.start, r.length)); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, p.closing)
+	return err
+}
@@ -0,0 +1,262 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"github.com/valivishy/httpfromtcp/internal/headers"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexFile serves the directory holding it
+const indexFile = "index.html"
+
+// sniffLength is how much of a file is looked at to guess its type when its extension is unknown
+const sniffLength = 512
+
+// Options tunes what FileServerWithOptions serves besides files
+type Options struct {
+	// Listing renders an index of the directories without index.html, as HTML or as JSON when the client
+	// prefers it. The entries are sorted by the sort query parameter, name, size or mtime, in the order
+	// given by the order parameter, asc or desc.
+	Listing bool
+	// ShowHidden serves and lists the files and directories whose name starts with a dot, which are not found otherwise
+	ShowHidden bool
+}
+
+// FileServer returns a handler serving the files under root, the request path being resolved inside it.
+// Paths escaping root, symbolic links included, are not found. A directory is served by its index.html.
+func FileServer(root string) server.Handler {
+	return FileServerWithOptions(root, Options{})
+}
+
+func FileServerWithOptions(root string, options Options) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if req.RequestLine.Method != http.MethodGet && req.RequestLine.Method != http.MethodHead {
+			h := headers.New()
+			h.Set("Allow", "GET, HEAD")
+			return &server.HandlerError{StatusCode: int(response.MethodNotAllowed), Message: "Method Not Allowed\n", Headers: h}
+		}
+
+		name, ok := relativePath(req.URL.Path)
+		if !ok || (!options.ShowHidden && hidden(name)) {
+			return notFound()
+		}
+
+		dir, err := os.OpenRoot(root)
+		if err != nil {
+			return internalError(err)
+		}
+		defer closeFile(dir)
+
+		file, err := dir.Open(name)
+		if err != nil {
+			return openError(err)
+		}
+		defer closeFile(file)
+
+		info, err := file.Stat()
+		if err != nil {
+			return internalError(err)
+		}
+		if !info.IsDir() {
+			return serveContent(w, req, file, info)
+		}
+
+		// Relative links in the index resolve against the directory only with the trailing slash
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			return redirectToDirectory(req.URL)
+		}
+
+		index, err := dir.Open(path.Join(name, indexFile))
+		if err == nil {
+			defer closeFile(index)
+			if indexInfo, err := index.Stat(); err == nil && indexInfo.Mode().IsRegular() {
+				return serveContent(w, req, index, indexInfo)
+			}
+		}
+
+		if !options.Listing {
+			return notFound()
+		}
+
+		return serveListing(w, req, file, options.ShowHidden)
+	}
+}
+
+// relativePath turns the request path into a name relative to the root, "." being the root itself
+func relativePath(requestPath string) (string, bool) {
+	// Asterisk-form and authority-form targets have no path
+	if requestPath == "" || strings.ContainsRune(requestPath, 0) {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+requestPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	return name, true
+}
+
+// hidden reports whether a segment of name starts with a dot, "." being the root itself
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if segment != "." && strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redirectToDirectory(u *request.URL) *server.HandlerError {
+	location := u.RawPath + "/"
+	if u.RawQuery != "" {
+		location += "?" + u.RawQuery
+	}
+
+	h := headers.New()
+	h.Set("Location", location)
+	return &server.HandlerError{StatusCode: int(response.MovedPermanently), Message: "Moved Permanently\n", Headers: h}
+}
+
+// serveContent answers with the file, a part of it or nothing at all, as the conditional and range fields
+// of req ask, see RFC 9110 sections 13 and 14
+func serveContent(w *response.Writer, req *request.Request, file *os.File, info fs.FileInfo) *server.HandlerError {
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+
+	h := headers.New()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+
+	switch checkPreconditions(req.Headers, etag, modTime) {
+	case preconditionFailed:
+		return &server.HandlerError{StatusCode: int(response.PreconditionFailed), Message: "Precondition Failed\n"}
+	case notModified:
+		if err := writeHead(w, response.NotModified, h); err != nil {
+			fmt.Printf("warning: failed to send %s: %v\n", file.Name(), err)
+		}
+		return nil
+	}
+
+	contentType, err := detectContentType(file)
+	if err != nil {
+		return internalError(err)
+	}
+
+	size := info.Size()
+	ranges := []byteRange{{start: 0, length: size}}
+	if value, ok := req.Headers.Get("Range"); ok && rangeApplies(req.Headers, etag, modTime) {
+		parsed, err := parseRange(value, size)
+		switch {
+		case errors.Is(err, errUnsatisfiable):
+			rangeHeaders := headers.New()
+			rangeHeaders.Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			return &server.HandlerError{StatusCode: int(response.RangeNotSatisfiable), Message: "Range Not Satisfiable\n", Headers: rangeHeaders}
+		case err == nil:
+			ranges = parsed
+		}
+		// A malformed Range is ignored and the whole file sent
+	}
+
+	if err = sendRanges(w, file, h, ranges, contentType, size); err != nil {
+		fmt.Printf("warning: failed to send %s: %v\n", file.Name(), err)
+	}
+
+	return nil
+}
+
+// sendRanges writes the whole file with 200, a single range with 206 or several as multipart/byteranges with 206
+func sendRanges(w *response.Writer, file *os.File, h *headers.Headers, ranges []byteRange, contentType string, size int64) error {
+	statusCode := response.PartialContent
+	var parts *rangeParts
+	switch {
+	case len(ranges) == 1 && ranges[0].length == size:
+		statusCode = response.OK
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+	case len(ranges) == 1:
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Range", ranges[0].contentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].length, 10))
+	default:
+		parts = newRangeParts(ranges, contentType, size)
+		h.Set("Content-Type", "multipart/byteranges; boundary="+parts.boundary)
+		h.Set("Content-Length", strconv.FormatInt(parts.length(), 10))
+	}
+
+	if err := writeHead(w, statusCode, h); err != nil {
+		return err
+	}
+	// HEAD responses are written without reading the file
+	if w.OmitBody {
+		return nil
+	}
+	if parts != nil {
+		return parts.write(w, file)
+	}
+
+	_, err := io.Copy(w, io.NewSectionReader(file, ranges[0].start, ranges[0].length))
+	return err
+}
+
+// detectContentType guesses from the extension, then from the first bytes of the file
+func detectContentType(file *os.File) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(file.Name())); contentType != "" {
+		return contentType, nil
+	}
+
+	buffer := make([]byte, sniffLength)
+	n, err := file.ReadAt(buffer, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buffer[:n]), nil
+}
+
+func writeHead(w *response.Writer, statusCode response.StatusCode, h *headers.Headers) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+
+	return w.WriteHeaders(h)
+}
+
+// openError answers 403 when the file may not be read and 404 when it cannot be found under the root,
+// which includes paths escaping it and files used as directories
+func openError(err error) *server.HandlerError {
+	if errors.Is(err, fs.ErrPermission) {
+		return &server.HandlerError{StatusCode: int(response.Forbidden), Message: "Forbidden\n"}
+	}
+
+	return notFound()
+}
+
+func notFound() *server.HandlerError {
+	return &server.HandlerError{StatusCode: int(response.NotFound), Message: "Not Found\n"}
+}
+
+func internalError(err error) *server.HandlerError {
+	fmt.Printf("warning: failed to serve file: %v\n", err)
+	return &server.HandlerError{StatusCode: int(response.InternalServerError), Message: "Internal Server Error\n"}
+}
+
+func closeFile(closer io.Closer) {
+	if err := closer.Close(); err != nil {
+		fmt.Printf("warning: failed to close file: %v\n", err)
+	}
+}
@@ -0,0 +1,331 @@
+package static
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valivishy/httpfromtcp/internal/request"
+	"github.com/valivishy/httpfromtcp/internal/response"
+	"github.com/valivishy/httpfromtcp/internal/server"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var modTime = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+const content = "0123456789abcdefghij"
+
+// root creates a directory holding a few files with a known modification time
+func root(t *testing.T) string {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"notes.txt":       content,
+		"app.js":          "console.log(1)",
+		"noext":           "<html><body>hi</body></html>",
+		"assets/logo.svg": "<svg></svg>",
+	} {
+		name = filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(t, os.WriteFile(name, []byte(data), 0o644))
+		require.NoError(t, os.Chtimes(name, modTime, modTime))
+	}
+
+	return dir
+}
+
+// get runs handler on the request with the extra header lines and reads back what it answered
+func get(t *testing.T, handler server.Handler, method, target, extra string) (*response.Response, string) {
+	req, err := request.FromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	w := response.NewWriter(&b)
+	w.OmitBody = method == http.MethodHead
+	if handlerError := handler(w, req); handlerError != nil {
+		require.False(t, w.Started())
+		require.NoError(t, server.WriteHandlerError(w, *handlerError))
+	}
+	require.NoError(t, w.Finish())
+
+	resp, err := response.Read(bufio.NewReader(&b), method)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestFileServerServesFiles(t *testing.T) {
+	handler := FileServer(root(t))
+
+	resp, body := get(t, handler, "GET", "/notes.txt", "")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, content, body)
+	contentType, _ := resp.Headers.Get("Content-Type")
+	assert.Equal(t, "text/plain; charset=utf-8", contentType)
+	lastModified, _ := resp.Headers.Get("Last-Modified")
+	assert.Equal(t, "Fri, 01 Mar 2024 12:30:00 GMT", lastModified)
+	etag, _ := resp.Headers.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]+-14"$`, etag)
+
+	// Test: The type comes from the extension, or from the content when there is none
+	resp, _ = get(t, handler, "GET", "/assets/logo.svg", "")
+	contentType, _ = resp.Headers.Get("Content-Type")
+	assert.Equal(t, mime.TypeByExtension(".svg"), contentType)
+	resp, _ = get(t, handler, "GET", "/noext", "")
+	contentType, _ = resp.Headers.Get("Content-Type")
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+
+	// Test: HEAD gets the same fields without the body
+	resp, body = get(t, handler, "HEAD", "/notes.txt", "")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	contentLength, _ := resp.Headers.Get("Content-Length")
+	assert.Equal(t, "20", contentLength)
+	assert.Empty(t, body)
+
+	resp, _ = get(t, handler, "POST", "/notes.txt", "Content-Length: 0\r\n")
+	assert.Equal(t, response.MethodNotAllowed, resp.StatusCode)
+	allow, _ := resp.Headers.Get("Allow")
+	assert.Equal(t, "GET, HEAD", allow)
+}
+
+func TestFileServerStaysInRoot(t *testing.T) {
+	dir := root(t)
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link.txt")))
+	handler := FileServer(dir)
+
+	for _, target := range []string{"/../secret.txt", "/assets/../../secret.txt", "/%2e%2e/secret.txt", "/link.txt",
+		"/missing.txt", "/notes.txt/more", "/"} {
+		resp, body := get(t, handler, "GET", target, "")
+		assert.Equal(t, response.NotFound, resp.StatusCode, target)
+		assert.NotContains(t, body, "secret", target)
+	}
+
+	// Test: Dot segments resolving inside the root are fine
+	resp, body := get(t, handler, "GET", "/assets/../notes.txt", "")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, content, body)
+}
+
+func TestFileServerConditionalRequests(t *testing.T) {
+	handler := FileServer(root(t))
+	resp, _ := get(t, handler, "GET", "/notes.txt", "")
+	etag, _ := resp.Headers.Get("ETag")
+
+	for _, tc := range []struct {
+		name     string
+		extra    string
+		expected response.StatusCode
+	}{
+		{"matching etag", "If-None-Match: \"other\", " + etag + "\r\n", response.NotModified},
+		{"weak etag", "If-None-Match: W/" + etag + "\r\n", response.NotModified},
+		{"any etag", "If-None-Match: *\r\n", response.NotModified},
+		{"other etag", "If-None-Match: \"other\"\r\n", response.OK},
+		{"not modified since", "If-Modified-Since: Fri, 01 Mar 2024 12:30:00 GMT\r\n", response.NotModified},
+		{"modified since", "If-Modified-Since: Fri, 01 Mar 2024 12:29:59 GMT\r\n", response.OK},
+		{"invalid date", "If-Modified-Since: yesterday\r\n", response.OK},
+		{"etag wins over date", "If-None-Match: \"other\"\r\nIf-Modified-Since: Fri, 01 Mar 2024 12:30:00 GMT\r\n", response.OK},
+		{"if-match", "If-Match: " + etag + "\r\n", response.OK},
+		{"if-match weak", "If-Match: W/" + etag + "\r\n", response.PreconditionFailed},
+		{"if-match other", "If-Match: \"other\"\r\n", response.PreconditionFailed},
+		{"unmodified since", "If-Unmodified-Since: Fri, 01 Mar 2024 12:29:59 GMT\r\n", response.PreconditionFailed},
+	} {
+		resp, body := get(t, handler, "GET", "/notes.txt", tc.extra)
+		assert.Equal(t, tc.expected, resp.StatusCode, tc.name)
+		if tc.expected == response.NotModified {
+			assert.Empty(t, body, tc.name)
+			value, _ := resp.Headers.Get("ETag")
+			assert.Equal(t, etag, value, tc.name)
+		}
+	}
+}
+
+func TestFileServerRanges(t *testing.T) {
+	handler := FileServer(root(t))
+
+	for _, tc := range []struct {
+		rangeHeader  string
+		body         string
+		contentRange string
+	}{
+		{"bytes=0-4", "01234", "bytes 0-4/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+		{"bytes=18-100", "ij", "bytes 18-19/20"},
+		{"bytes=-100", content, ""},
+	} {
+		resp, body := get(t, handler, "GET", "/notes.txt", "Range: "+tc.rangeHeader+"\r\n")
+		assert.Equal(t, tc.body, body, tc.rangeHeader)
+		contentRange, _ := resp.Headers.Get("Content-Range")
+		assert.Equal(t, tc.contentRange, contentRange, tc.rangeHeader)
+		if tc.contentRange != "" {
+			assert.Equal(t, response.PartialContent, resp.StatusCode, tc.rangeHeader)
+		}
+	}
+
+	// Test: Ranges past the end are not satisfiable
+	resp, _ := get(t, handler, "GET", "/notes.txt", "Range: bytes=20-30, -0\r\n")
+	assert.Equal(t, response.RangeNotSatisfiable, resp.StatusCode)
+	contentRange, _ := resp.Headers.Get("Content-Range")
+	assert.Equal(t, "bytes */20", contentRange)
+
+	// Test: Malformed ranges and overlapping ones adding up past the file are ignored
+	for _, rangeHeader := range []string{"bytes=5-1", "items=0-4", "bytes=a-b", "bytes=", "bytes=0-19,0-19"} {
+		resp, body := get(t, handler, "GET", "/notes.txt", "Range: "+rangeHeader+"\r\n")
+		assert.Equal(t, response.OK, resp.StatusCode, rangeHeader)
+		assert.Equal(t, content, body, rangeHeader)
+	}
+
+	// Test: If-Range only lets the range through for the current version of the file
+	resp, body := get(t, handler, "GET", "/notes.txt", "Range: bytes=0-4\r\nIf-Range: \"stale\"\r\n")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, content, body)
+	resp, body = get(t, handler, "GET", "/notes.txt", "Range: bytes=0-4\r\nIf-Range: Fri, 01 Mar 2024 12:30:00 GMT\r\n")
+	assert.Equal(t, response.PartialContent, resp.StatusCode)
+	assert.Equal(t, "01234", body)
+}
+
+func TestFileServerMultipleRanges(t *testing.T) {
+	handler := FileServer(root(t))
+
+	resp, body := get(t, handler, "GET", "/notes.txt", "Range: bytes=0-1, 10-12, -2\r\n")
+	assert.Equal(t, response.PartialContent, resp.StatusCode)
+	contentType, _ := resp.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	// Test: Every part carries its own type and range, and the announced length is exact
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts, ranges []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		data, err := io.ReadAll(part)
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", part.Header.Get("Content-Type"))
+		parts = append(parts, string(data))
+		ranges = append(ranges, part.Header.Get("Content-Range"))
+	}
+	assert.Equal(t, []string{"01", "abc", "ij"}, parts)
+	assert.Equal(t, []string{"bytes 0-1/20", "bytes 10-12/20", "bytes 18-19/20"}, ranges)
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+}
+
+// listingRoot adds hidden files and an empty directory to root
+func listingRoot(t *testing.T) string {
+	dir := root(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("SECRET=1"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0o755))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "empty dir"), 0o755))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "app.js"), modTime.Add(time.Hour), modTime.Add(time.Hour)))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "assets"), modTime, modTime))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "empty dir"), modTime.Add(2*time.Hour), modTime.Add(2*time.Hour)))
+
+	return dir
+}
+
+func TestFileServerDirectories(t *testing.T) {
+	dir := listingRoot(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "index.html"), []byte("<p>assets</p>"), 0o644))
+	handler := FileServer(dir)
+
+	// Test: A directory is served by its index.html once the path ends with a slash
+	resp, body := get(t, handler, "GET", "/assets/", "")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "<p>assets</p>", body)
+	resp, _ = get(t, handler, "GET", "/assets?x=1", "")
+	assert.Equal(t, response.MovedPermanently, resp.StatusCode)
+	location, _ := resp.Headers.Get("Location")
+	assert.Equal(t, "/assets/?x=1", location)
+
+	// Test: Without listing a directory without index is not found, and hidden files never are
+	for _, target := range []string{"/", "/.env", "/.git/", "/assets/../.env"} {
+		resp, _ = get(t, handler, "GET", target, "")
+		assert.Equal(t, response.NotFound, resp.StatusCode, target)
+	}
+
+	resp, body = get(t, FileServerWithOptions(dir, Options{ShowHidden: true}), "GET", "/.env", "")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	assert.Equal(t, "SECRET=1", body)
+}
+
+func TestFileServerListing(t *testing.T) {
+	dir := listingRoot(t)
+	handler := FileServerWithOptions(dir, Options{Listing: true})
+
+	resp, body := get(t, handler, "GET", "/", "Accept: text/html,application/json;q=0.9\r\n")
+	assert.Equal(t, response.OK, resp.StatusCode)
+	contentType, _ := resp.Headers.Get("Content-Type")
+	assert.Equal(t, "text/html; charset=utf-8", contentType)
+	vary, _ := resp.Headers.Get("Vary")
+	assert.Equal(t, "Accept", vary)
+	assert.Contains(t, body, "<title>Index of /</title>")
+	assert.Contains(t, body, `<a href="./empty%20dir/">empty dir/</a>`)
+	assert.Contains(t, body, `<a href="./notes.txt">notes.txt</a></td><td>20</td>`)
+	assert.NotContains(t, body, ".env")
+	assert.NotContains(t, body, `href="../"`)
+
+	// Test: JSON is sent to clients preferring it, sorted as asked with directories first
+	for _, tc := range []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"assets", "empty dir", "app.js", "noext", "notes.txt"}},
+		{"?sort=name&order=desc", []string{"empty dir", "assets", "notes.txt", "noext", "app.js"}},
+		{"?sort=size", []string{"assets", "empty dir", "app.js", "notes.txt", "noext"}},
+		{"?sort=mtime&order=desc", []string{"empty dir", "assets", "app.js", "notes.txt", "noext"}},
+	} {
+		resp, body = get(t, handler, "GET", "/"+tc.query, "Accept: application/json\r\n")
+		contentType, _ = resp.Headers.Get("Content-Type")
+		assert.Equal(t, "application/json", contentType)
+
+		var l listing
+		require.NoError(t, json.Unmarshal([]byte(body), &l))
+		var names []string
+		for _, e := range l.Entries {
+			names = append(names, e.Name)
+		}
+		assert.Equal(t, tc.expected, names, tc.query)
+	}
+
+	// Test: A name with a colon links relatively rather than as a scheme
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a:b"), nil, 0o644))
+	_, body = get(t, handler, "GET", "/", "")
+	assert.Contains(t, body, `<a href="./a:b">a:b</a>`)
+
+	// Test: Subdirectories link back to their parent, and unknown sort keys are refused
+	_, body = get(t, handler, "GET", "/empty%20dir/", "")
+	assert.Contains(t, body, `<a href="../">../</a>`)
+	assert.Contains(t, body, "Index of /empty dir/")
+	resp, _ = get(t, handler, "GET", "/?sort=owner", "")
+	assert.Equal(t, response.BadRequest, resp.StatusCode)
+
+	// Test: Hidden entries are listed when shown
+	_, body = get(t, FileServerWithOptions(listingRoot(t), Options{Listing: true, ShowHidden: true}), "GET", "/", "")
+	assert.Contains(t, body, `<a href="./.git/">.git/</a>`)
+	assert.Contains(t, body, `<a href="./.env">.env</a>`)
+}
+
+func TestPrefersJSON(t *testing.T) {
+	assert.True(t, prefersJSON("application/json"))
+	assert.True(t, prefersJSON("text/html;q=0.5, application/*"))
+	assert.False(t, prefersJSON(""))
+	assert.False(t, prefersJSON("*/*"))
+	assert.False(t, prefersJSON("application/json;q=0.8, */*"))
+	assert.False(t, prefersJSON("text/*, application/json;q=0"))
+}